@@ -0,0 +1,196 @@
+package dockerutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// apiError is returned for unsuccessful responses from the Engine API.
+type apiError struct {
+	statusCode int
+	message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("docker: %d %s", e.statusCode, e.message)
+}
+
+// apiRequest performs a request against the Docker Engine API. It is used for
+// endpoints which the dockerclient.Client interface does not expose. A 404
+// response results in dockerclient.ErrNotFound, other unsuccessful responses
+// in an *apiError. The caller must close the body of the returned response.
+func apiRequest(
+	d *dockerclient.DockerClient,
+	method, path string,
+	query url.Values,
+	body io.Reader,
+	header http.Header,
+) (*http.Response, error) {
+	u := d.URL.String() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	res, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, dockerclient.ErrNotFound
+	}
+	if res.StatusCode >= 400 {
+		defer res.Body.Close()
+		return nil, readAPIError(res)
+	}
+	return res, nil
+}
+
+// hijack performs a request which upgrades the connection to a raw stream, as
+// used for attaching to a container or exec instance. The response has been
+// read from the returned reader, which holds the output stream. The caller
+// must close the connection.
+func hijack(
+	d *dockerclient.DockerClient,
+	method, path string,
+	body []byte,
+) (net.Conn, *bufio.Reader, error) {
+	conn, err := dial(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequest(method, d.URL.String()+path, bytes.NewReader(body))
+	if err != nil {
+		conn.Close()
+		return nil, nil, stackerr.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, stackerr.Wrap(err)
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, stackerr.Wrap(err)
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		conn.Close()
+		return nil, nil, dockerclient.ErrNotFound
+	case res.StatusCode >= 400:
+		defer conn.Close()
+		return nil, nil, readAPIError(res)
+	}
+	// older daemons answer with a plain 200 rather than upgrading
+	return conn, br, nil
+}
+
+// dial connects to the daemon the same way the client does, including over a
+// unix socket or TLS.
+func dial(d *dockerclient.DockerClient) (net.Conn, error) {
+	host := d.URL.Host
+	if d.URL.Port() == "" {
+		if d.URL.Scheme == "https" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	t, _ := d.HTTPClient.Transport.(*http.Transport)
+	switch {
+	case t != nil && t.DialContext != nil:
+		conn, err = t.DialContext(context.Background(), "tcp", host)
+	case t != nil && t.Dial != nil:
+		conn, err = t.Dial("tcp", host)
+	default:
+		conn, err = net.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	if d.URL.Scheme == "https" {
+		config := &tls.Config{}
+		if d.TLSConfig != nil {
+			config = d.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = d.URL.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, stackerr.Wrap(err)
+		}
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// closeWrite closes the writing side of the connection, signaling the end of
+// the input, if the connection supports it.
+func closeWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return stackerr.Wrap(c.CloseWrite())
+	}
+	return nil
+}
+
+func readAPIError(res *http.Response) error {
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+
+	// newer daemons send {"message": "..."}, older ones plain text
+	var m struct{ Message string }
+	if json.Unmarshal(data, &m) == nil && m.Message != "" {
+		return &apiError{statusCode: res.StatusCode, message: m.Message}
+	}
+	return &apiError{statusCode: res.StatusCode, message: strings.TrimSpace(string(data))}
+}
+
+// registryAuthHeader returns the X-Registry-Auth header for the credentials.
+func registryAuthHeader(ac *dockerclient.AuthConfig) (http.Header, error) {
+	header := http.Header{}
+	if ac == nil {
+		return header, nil
+	}
+	data, err := json.Marshal(ac)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString(data))
+	return header, nil
+}
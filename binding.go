@@ -19,7 +19,11 @@ func BindingAddr(d dockerclient.Client, name, binding string) (string, error) {
 	if err != nil {
 		return "", stackerr.Wrap(err)
 	}
+	return bindingAddr(d, ci, name, binding)
+}
 
+// bindingAddr provides the address for the binding of the inspected container.
+func bindingAddr(d dockerclient.Client, ci *dockerclient.ContainerInfo, name, binding string) (string, error) {
 	ip, err := dockerIP(d)
 	if err != nil {
 		return "", err
@@ -34,11 +38,12 @@ func BindingAddr(d dockerclient.Client, name, binding string) (string, error) {
 		hostname = ip.String()
 	}
 
-	addr := fmt.Sprintf(
-		"%s:%s",
-		hostname,
-		ci.NetworkSettings.Ports[binding][0].HostPort,
-	)
+	ports := ci.NetworkSettings.Ports[binding]
+	if len(ports) == 0 {
+		return "", stackerr.Newf("container %q has no host port for binding %q", name, binding)
+	}
+
+	addr := fmt.Sprintf("%s:%s", hostname, ports[0].HostPort)
 	return addr, nil
 }
 
@@ -0,0 +1,222 @@
+package dockerutil
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// BuildOptions configures BuildImage.
+type BuildOptions struct {
+	// Context is the directory sent to the daemon as the build context. Files
+	// matched by its .dockerignore file are left out.
+	Context string
+
+	// Dockerfile is the path of the Dockerfile relative to the Context. It
+	// defaults to "Dockerfile".
+	Dockerfile string
+
+	// Repository is the name of the built image. Its tag is the content hash
+	// of the context.
+	Repository string
+
+	// Output receives the output of the build. It is discarded if nil.
+	Output io.Writer
+}
+
+// BuildImage builds the image from the context directory, unless an image
+// built from the same content already exists. It returns the name of the
+// image, "<repository>:<hash>", where hash is the ContextHash of the context.
+func BuildImage(d *dockerclient.DockerClient, o BuildOptions) (string, error) {
+	dockerfile := o.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	files, err := contextFiles(o.Context, dockerfile)
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashContext(o.Context, dockerfile, files)
+	if err != nil {
+		return "", err
+	}
+
+	imageName := o.Repository + ":" + hash
+	ref, err := ParseReference(imageName)
+	if err != nil {
+		return "", err
+	}
+	id, err := imageIDFromList(d, ref)
+	if err != nil {
+		return "", err
+	}
+	if id != "" {
+		return imageName, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeContext(pw, o.Context, files))
+	}()
+	defer pr.Close()
+
+	query := url.Values{
+		"t":          {imageName},
+		"dockerfile": {dockerfile},
+		"rm":         {"1"},
+	}
+	header := http.Header{"Content-Type": {"application/x-tar"}}
+	res, err := apiRequest(d, "POST", "/build", query, pr, header)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	output := o.Output
+	if output == nil {
+		output = ioutil.Discard
+	}
+	if err := decodeBuildOutput(res.Body, imageName, output); err != nil {
+		return "", err
+	}
+	return imageName, nil
+}
+
+// ContextHash returns the content hash of the build context, which is used to
+// tag the images built by BuildImage. It covers the names, types, modes and
+// contents of the files which are not excluded by the .dockerignore file, as
+// well as the name of the Dockerfile. Modification times are not included, so
+// touching a file doesn't cause a rebuild.
+func ContextHash(context, dockerfile string) (string, error) {
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	files, err := contextFiles(context, dockerfile)
+	if err != nil {
+		return "", err
+	}
+	return hashContext(context, dockerfile, files)
+}
+
+// contextFiles returns the slash separated paths of the files in the context,
+// relative to it, in lexical order. The Dockerfile and .dockerignore are
+// always included, as the daemon needs them.
+func contextFiles(context, dockerfile string) ([]string, error) {
+	m, err := readDockerignore(context)
+	if err != nil {
+		return nil, err
+	}
+	dockerfile = path.Clean(filepath.ToSlash(dockerfile))
+	if strings.HasPrefix(dockerfile, "../") || path.IsAbs(dockerfile) {
+		return nil, stackerr.Newf("Dockerfile %q is outside of the context", dockerfile)
+	}
+
+	var files []string
+	err = filepath.Walk(context, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return stackerr.Wrap(err)
+		}
+		rel, err := filepath.Rel(context, p)
+		if err != nil {
+			return stackerr.Wrap(err)
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != dockerfile && rel != ".dockerignore" && m.ignored(rel) {
+			if info.IsDir() && !m.mayReinclude() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func hashContext(context, dockerfile string, files []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "dockerfile %s\x00", path.Clean(filepath.ToSlash(dockerfile)))
+	for _, name := range files {
+		p := filepath.Join(context, filepath.FromSlash(name))
+		info, err := os.Lstat(p)
+		if err != nil {
+			return "", stackerr.Wrap(err)
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", name, info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return "", stackerr.Wrap(err)
+			}
+			fmt.Fprintf(h, "%s\x00", link)
+		case info.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return "", stackerr.Wrap(err)
+			}
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return "", stackerr.Wrap(err)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// writeContext writes the files of the context as a tar archive.
+func writeContext(w io.Writer, context string, files []string) error {
+	tw := tar.NewWriter(w)
+	for _, name := range files {
+		p := filepath.Join(context, filepath.FromSlash(name))
+		if err := writeTarFile(tw, p, name, nil); err != nil {
+			return err
+		}
+	}
+	return stackerr.Wrap(tw.Close())
+}
+
+// decodeBuildOutput copies the build output from the JSON stream to w. An
+// error message in the stream is returned.
+func decodeBuildOutput(r io.Reader, imageName string, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	for {
+		var m jsonMessage
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return stackerr.Wrap(err)
+		}
+		if m.Error != "" {
+			return stackerr.Newf("building %q: %s", imageName, m.Error)
+		}
+		text := m.Stream
+		if text == "" && m.Status != "" {
+			text = m.Status + "\n"
+		}
+		if _, err := io.WriteString(w, text); err != nil {
+			return stackerr.Wrap(err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package dockerutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func writeTestContext(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "dockerutil-build")
+	ensure.Nil(t, err)
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		ensure.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+		ensure.Nil(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestDockerignore(t *testing.T) {
+	m, err := parseDockerignore(strings.NewReader(`
+# comment
+*.log
+/tmp
+**/node_modules
+docs/**/*.md
+!docs/keep.md
+build/
+!build/out.bin
+`))
+	ensure.Nil(t, err)
+
+	cases := map[string]bool{
+		"a.log":                  true,
+		"dir/a.log":              false,
+		"tmp":                    true,
+		"tmp/x":                  true,
+		"src/tmp":                false,
+		"node_modules":           true,
+		"web/node_modules/react": true,
+		"docs/a.md":              true,
+		"docs/x/y/a.md":          true,
+		"docs/keep.md":           false,
+		"docs/a.txt":             false,
+		"build":                  true,
+		"build/x.o":              true,
+		"build/out.bin":          false,
+		"main.go":                false,
+	}
+	for name, ignored := range cases {
+		if m.ignored(name) != ignored {
+			t.Errorf("ignored(%q) = %v, expected %v", name, !ignored, ignored)
+		}
+	}
+	ensure.True(t, m.mayReinclude())
+}
+
+func TestDockerignoreCharacterClass(t *testing.T) {
+	m, err := parseDockerignore(strings.NewReader("[!a]*.txt\nlog[0-9]\nx[^y]z\n"))
+	ensure.Nil(t, err)
+
+	cases := map[string]bool{
+		"b.txt":    true,
+		"a.txt":    false,
+		"!.txt":    true,
+		"log1":     true,
+		"logx":     false,
+		"xaz":      true,
+		"xyz":      false,
+		"x/z":      false,
+		"notes.md": false,
+	}
+	for name, ignored := range cases {
+		if m.ignored(name) != ignored {
+			t.Errorf("ignored(%q) = %v, expected %v", name, !ignored, ignored)
+		}
+	}
+}
+
+func TestDockerignoreInvalidPattern(t *testing.T) {
+	_, err := parseDockerignore(strings.NewReader("[a"))
+	ensure.Err(t, err, regexp.MustCompile(`invalid .dockerignore pattern "\[a"`))
+}
+
+func TestContextFiles(t *testing.T) {
+	dir := writeTestContext(t, map[string]string{
+		"Dockerfile":        "FROM scratch",
+		".dockerignore":     "*.log\nvendor\nDockerfile\n.dockerignore\n",
+		"main.go":           "package main",
+		"debug.log":         "noise",
+		"vendor/lib/lib.go": "package lib",
+		"pkg/pkg.go":        "package pkg",
+	})
+	defer os.RemoveAll(dir)
+
+	files, err := contextFiles(dir, "Dockerfile")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, files, []string{
+		".dockerignore",
+		"Dockerfile",
+		"main.go",
+		"pkg",
+		"pkg/pkg.go",
+	})
+}
+
+func TestContextFilesDockerfileOutside(t *testing.T) {
+	_, err := contextFiles(os.TempDir(), "../Dockerfile")
+	ensure.Err(t, err, regexp.MustCompile("outside of the context"))
+}
+
+func TestContextHash(t *testing.T) {
+	dir := writeTestContext(t, map[string]string{
+		"Dockerfile":    "FROM scratch",
+		".dockerignore": "*.log",
+		"main.go":       "package main",
+	})
+	defer os.RemoveAll(dir)
+
+	hash, err := ContextHash(dir, "")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(hash), 12)
+
+	// ignored files do not change the hash
+	ensure.Nil(t, ioutil.WriteFile(filepath.Join(dir, "debug.log"), []byte("x"), 0644))
+	same, err := ContextHash(dir, "Dockerfile")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, same, hash)
+
+	// but other files do
+	ensure.Nil(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package other"), 0644))
+	changed, err := ContextHash(dir, "")
+	ensure.Nil(t, err)
+	ensure.NotDeepEqual(t, changed, hash)
+}
+
+func TestBuildImage(t *testing.T) {
+	dir := writeTestContext(t, map[string]string{
+		"build/Dockerfile": "FROM scratch\nCOPY main.go /",
+		".dockerignore":    "*.log",
+		"main.go":          "package main",
+		"debug.log":        "noise",
+	})
+	defer os.RemoveAll(dir)
+
+	hash, err := ContextHash(dir, "build/Dockerfile")
+	ensure.Nil(t, err)
+	imageName := "app:" + hash
+
+	var built []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/json"):
+			if len(built) == 0 {
+				io.WriteString(w, `[]`)
+				return
+			}
+			fmt.Fprintf(w, `[{"Id": "a", "RepoTags": ["%s"]}]`, imageName)
+		case r.URL.Path == "/build":
+			ensure.DeepEqual(t, r.URL.Query().Get("t"), imageName)
+			ensure.DeepEqual(t, r.URL.Query().Get("dockerfile"), "build/Dockerfile")
+			ensure.DeepEqual(t, r.Header.Get("Content-Type"), "application/x-tar")
+
+			tr := tar.NewReader(r.Body)
+			for {
+				h, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				ensure.Nil(t, err)
+				built = append(built, h.Name)
+			}
+			io.WriteString(w, `{"stream":"Step 1 : FROM scratch\n"}`+"\n")
+			io.WriteString(w, `{"stream":"Successfully built a\n"}`+"\n")
+		default:
+			t.Fatalf("unexpected request %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+
+	var output bytes.Buffer
+	o := BuildOptions{
+		Context:    dir,
+		Dockerfile: "build/Dockerfile",
+		Repository: "app",
+		Output:     &output,
+	}
+	name, err := BuildImage(d, o)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, name, imageName)
+	ensure.DeepEqual(t, built, []string{".dockerignore", "build/", "build/Dockerfile", "main.go"})
+	ensure.DeepEqual(t, output.String(), "Step 1 : FROM scratch\nSuccessfully built a\n")
+
+	// the image exists now, so it isn't built again
+	built = []string{"done"}
+	name, err = BuildImage(d, o)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, name, imageName)
+	ensure.DeepEqual(t, built, []string{"done"})
+}
+
+func TestDecodeBuildOutputError(t *testing.T) {
+	stream := `{"stream":"Step 1 : FROM nope\n"}
+{"error":"pull access denied for nope","errorDetail":{"message":"pull access denied"}}
+`
+	err := decodeBuildOutput(strings.NewReader(stream), "app:1", ioutil.Discard)
+	ensure.Err(t, err, regexp.MustCompile(`building "app:1": pull access denied`))
+}
@@ -0,0 +1,280 @@
+package dockerutil
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	bundleManifestName = "manifest.json"
+	bundleImagesName   = "images.tar"
+)
+
+// BundleManifest describes the images in a bundle.
+type BundleManifest struct {
+	Images []BundleImage
+}
+
+// BundleImage is an image in a bundle.
+type BundleImage struct {
+	// Reference is the normalized reference the image was requested as.
+	Reference string
+
+	// ID is the ID of the image.
+	ID string
+
+	// Digest is the digest the reference is pinned to, if any. The daemon
+	// doesn't keep digests of loaded images, so these images are found by
+	// their ID once loaded.
+	Digest string `json:",omitempty"`
+}
+
+// find returns the bundled image for the reference, if any.
+func (m *BundleManifest) find(ref *Reference) *BundleImage {
+	for i, bi := range m.Images {
+		if bi.Reference == ref.String() {
+			return &m.Images[i]
+		}
+	}
+	return nil
+}
+
+// SaveBundle writes a bundle with the given images to w, for loading on hosts
+// without access to a registry. Images which are not available locally are
+// pulled first. A bundle is a tar file holding a manifest.json with a
+// BundleManifest, followed by an images.tar as produced by "docker save".
+func SaveBundle(
+	d *dockerclient.DockerClient,
+	w io.Writer,
+	images []string,
+	auth *dockerclient.AuthConfig,
+) error {
+	var manifest BundleManifest
+	names := url.Values{}
+	seen := map[string]bool{}
+	for _, image := range images {
+		ref, err := ParseReference(image)
+		if err != nil {
+			return err
+		}
+		if seen[ref.String()] {
+			continue
+		}
+		seen[ref.String()] = true
+
+		id, err := ImageID(d, image, auth)
+		if err != nil {
+			return err
+		}
+		manifest.Images = append(manifest.Images, BundleImage{
+			Reference: ref.String(),
+			ID:        id,
+			Digest:    ref.Digest,
+		})
+
+		// saving by tag preserves it, digests are not preserved by the daemon
+		// so those are saved by ID and resolved through the manifest instead
+		if ref.Digest != "" {
+			names.Add("names", id)
+		} else {
+			names.Add("names", ref.Familiar())
+		}
+	}
+
+	// the tar header needs the size, so the images are spooled to disk first
+	tmp, err := ioutil.TempFile("", "dockerutil-bundle")
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	res, err := apiRequest(d, "GET", "/images/get", names, nil, nil)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(tmp, res.Body)
+	res.Body.Close()
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return stackerr.Wrap(err)
+	}
+
+	manifestJSON, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+
+	tw := tar.NewWriter(w)
+	err = tw.WriteHeader(&tar.Header{
+		Name: bundleManifestName,
+		Mode: 0644,
+		Size: int64(len(manifestJSON)),
+	})
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	if _, err := tw.Write(manifestJSON); err != nil {
+		return stackerr.Wrap(err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name: bundleImagesName,
+		Mode: 0644,
+		Size: size,
+	})
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return stackerr.Wrap(err)
+	}
+	return stackerr.Wrap(tw.Close())
+}
+
+// LoadBundle loads the images from a bundle written by SaveBundle into the
+// daemon, and returns the manifest of the bundle.
+func LoadBundle(d dockerclient.Client, r io.Reader) (*BundleManifest, error) {
+	tr := tar.NewReader(r)
+	manifest, err := readBundleManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := tr.Next()
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	if h.Name != bundleImagesName {
+		return nil, stackerr.Newf("bundle has %q where %q was expected", h.Name, bundleImagesName)
+	}
+	if err := d.LoadImage(tr); err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	return manifest, nil
+}
+
+// LoadBundleFile is LoadBundle for the named file.
+func LoadBundleFile(d dockerclient.Client, file string) (*BundleManifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	defer f.Close()
+	return LoadBundle(d, f)
+}
+
+// ReadBundleManifest returns the manifest of a bundle without loading it.
+func ReadBundleManifest(r io.Reader) (*BundleManifest, error) {
+	return readBundleManifest(tar.NewReader(r))
+}
+
+func readBundleManifest(tr *tar.Reader) (*BundleManifest, error) {
+	h, err := tr.Next()
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	if h.Name != bundleManifestName {
+		return nil, stackerr.Newf("bundle has %q where %q was expected", h.Name, bundleManifestName)
+	}
+	var manifest BundleManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	return &manifest, nil
+}
+
+// WithBundleDir makes ImageID and CreateWithPull look for images which are
+// not available locally in the bundles, files ending in ".tar", in the given
+// directory. A bundle containing the image is loaded instead of pulling, even
+// with PullNever.
+func WithBundleDir(dir string) PullOption {
+	return func(o *pullOptions) {
+		o.bundleDir = dir
+	}
+}
+
+// loadFromBundle loads the bundle containing the reference from the bundle
+// directory. It returns the ID of the image, or an empty string if no bundle
+// contains the reference. A bundle is not loaded again if the image with the
+// ID it recorded is already present, which is how images pinned by digest are
+// found.
+func (o *pullOptions) loadFromBundle(d dockerclient.Client, ref *Reference) (string, error) {
+	file, bi, err := o.findBundle(ref)
+	if err != nil || bi == nil {
+		return "", err
+	}
+	present, err := o.hasImage(d, bi.ID)
+	if err != nil {
+		return "", err
+	}
+	if present {
+		return bi.ID, nil
+	}
+	if _, err := LoadBundleFile(d, file); err != nil {
+		return "", err
+	}
+	if o.index != nil {
+		o.index.Invalidate()
+	}
+	return bi.ID, nil
+}
+
+// findBundle returns the file of the first bundle in the bundle directory
+// which contains the reference, along with the image in its manifest. Files
+// which aren't bundles are skipped.
+func (o *pullOptions) findBundle(ref *Reference) (string, *BundleImage, error) {
+	if o.bundleDir == "" {
+		return "", nil, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(o.bundleDir, "*.tar"))
+	if err != nil {
+		return "", nil, stackerr.Wrap(err)
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", nil, stackerr.Wrap(err)
+		}
+		manifest, err := ReadBundleManifest(f)
+		f.Close()
+		if err != nil {
+			if o.logf != nil {
+				o.logf("skipping %s which is not a bundle: %s", file, err)
+			}
+			continue
+		}
+		if bi := manifest.find(ref); bi != nil {
+			return file, bi, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// hasImage reports if the image with the ID is present.
+func (o *pullOptions) hasImage(d dockerclient.Client, id string) (bool, error) {
+	if o.index != nil {
+		i, err := o.index.Image(id)
+		return i != nil, err
+	}
+	images, err := d.ListImages()
+	if err != nil {
+		return false, stackerr.Wrap(err)
+	}
+	for _, i := range images {
+		if i.Id == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
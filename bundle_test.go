@@ -0,0 +1,166 @@
+package dockerutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+const testImagesTar = "docker save output"
+
+func testBundle(t *testing.T, manifest *BundleManifest) []byte {
+	manifestJSON, err := json.Marshal(manifest)
+	ensure.Nil(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	ensure.Nil(t, tw.WriteHeader(&tar.Header{
+		Name: bundleManifestName,
+		Size: int64(len(manifestJSON)),
+	}))
+	_, err = tw.Write(manifestJSON)
+	ensure.Nil(t, err)
+	ensure.Nil(t, tw.WriteHeader(&tar.Header{
+		Name: bundleImagesName,
+		Size: int64(len(testImagesTar)),
+	}))
+	_, err = io.WriteString(tw, testImagesTar)
+	ensure.Nil(t, err)
+	ensure.Nil(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestLoadBundle(t *testing.T) {
+	given := &BundleManifest{
+		Images: []BundleImage{{Reference: "docker.io/library/redis:latest", ID: "a"}},
+	}
+	var loaded string
+	client := &mockClient{
+		loadImage: func(r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			ensure.Nil(t, err)
+			loaded = string(data)
+			return nil
+		},
+	}
+	manifest, err := LoadBundle(client, bytes.NewReader(testBundle(t, given)))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, manifest, given)
+	ensure.DeepEqual(t, loaded, testImagesTar)
+}
+
+func TestLoadBundleInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	ensure.Nil(t, tw.WriteHeader(&tar.Header{Name: "other"}))
+	ensure.Nil(t, tw.Close())
+	_, err := LoadBundle(&mockClient{}, &buf)
+	ensure.NotNil(t, err)
+}
+
+func TestImageIDFromBundleDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerutil-bundle-test")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	other := testBundle(t, &BundleManifest{
+		Images: []BundleImage{{Reference: "docker.io/library/other:latest", ID: "o"}},
+	})
+	ensure.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.tar"), other, 0644))
+	redis := testBundle(t, &BundleManifest{
+		Images: []BundleImage{{Reference: "docker.io/library/redis@" + testDigest, ID: "r"}},
+	})
+	ensure.Nil(t, ioutil.WriteFile(filepath.Join(dir, "b.tar"), redis, 0644))
+	ensure.Nil(t, ioutil.WriteFile(filepath.Join(dir, "0-unrelated.tar"), []byte("not a bundle"), 0644))
+
+	// the daemon doesn't keep the digest of loaded images
+	var loadCalls int
+	var loaded []*dockerclient.Image
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return loaded, nil
+		},
+		loadImage: func(r io.Reader) error {
+			loadCalls++
+			loaded = []*dockerclient.Image{{Id: "r"}}
+			return nil
+		},
+	}
+	var logs []string
+	logf := func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	id, err := ImageID(client, "redis@"+testDigest, nil, WithBundleDir(dir), WithPullPolicy(PullNever), WithLogf(logf))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, id, "r")
+	ensure.DeepEqual(t, loadCalls, 1)
+	ensure.DeepEqual(t, len(logs), 1)
+	ensure.StringContains(t, logs[0], "0-unrelated.tar which is not a bundle")
+
+	// the loaded image is found by its ID rather than loading it again
+	id, err = ImageID(client, "redis@"+testDigest, nil, WithBundleDir(dir), WithPullPolicy(PullNever))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, id, "r")
+	ensure.DeepEqual(t, loadCalls, 1)
+
+	_, err = ImageID(client, "missing", nil, WithBundleDir(dir), WithPullPolicy(PullNever))
+	_, ok := err.(*ImageNotPresentError)
+	ensure.True(t, ok)
+	ensure.DeepEqual(t, loadCalls, 1)
+}
+
+func TestSaveBundle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/json"):
+			fmt.Fprintf(w, `[
+				{"Id": "a", "RepoTags": ["redis:latest"]},
+				{"Id": "b", "RepoTags": ["app:1"], "RepoDigests": ["app@%s"]}
+			]`, testDigest)
+		case r.URL.Path == "/images/get":
+			ensure.DeepEqual(t, r.URL.Query()["names"], []string{"redis:latest", "b"})
+			io.WriteString(w, testImagesTar)
+		default:
+			t.Fatalf("unexpected request %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = SaveBundle(d, &buf, []string{"redis", "redis:latest", "app@" + testDigest}, nil)
+	ensure.Nil(t, err)
+
+	var loaded string
+	client := &mockClient{
+		loadImage: func(r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			ensure.Nil(t, err)
+			loaded = string(data)
+			return nil
+		},
+	}
+	manifest, err := LoadBundle(client, &buf)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, manifest, &BundleManifest{
+		Images: []BundleImage{
+			{Reference: "docker.io/library/redis:latest", ID: "a"},
+			{Reference: "docker.io/library/app@" + testDigest, ID: "b", Digest: testDigest},
+		},
+	})
+	ensure.DeepEqual(t, loaded, testImagesTar)
+}
@@ -0,0 +1,100 @@
+// Command dockergoal-bundle moves the images of a goal file to hosts without
+// access to a registry. On a connected host it saves them to a bundle:
+//
+//	dockergoal-bundle -goal goal.json -save bundle.tar
+//
+// and on the target host it loads the bundle, and optionally applies the goal:
+//
+//	dockergoal-bundle -load bundle.tar -goal goal.json -apply
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/dockerutil/dockergoal"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+func main() {
+	goalFile := flag.String("goal", "", "goal file with the containers")
+	authFile := flag.String("auth", "", "docker config file with registry credentials")
+	saveFile := flag.String("save", "", "save the images of the goal to this bundle")
+	loadFile := flag.String("load", "", "load the images from this bundle")
+	apply := flag.Bool("apply", false, "apply the goal after loading the bundle")
+	flag.Parse()
+
+	if err := run(*goalFile, *authFile, *saveFile, *loadFile, *apply); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(goalFile, authFile, saveFile, loadFile string, apply bool) error {
+	if (saveFile == "") == (loadFile == "") {
+		return fmt.Errorf("exactly one of -save and -load is required")
+	}
+	if (saveFile != "" || apply) && goalFile == "" {
+		return fmt.Errorf("-goal is required")
+	}
+
+	docker, err := dockerutil.BestEffortDockerClient()
+	if err != nil {
+		return err
+	}
+
+	// images which can't be matched after loading, such as digest pinned
+	// ones, are resolved through the bundle
+	var options []dockergoal.ContainerOption
+	if loadFile != "" {
+		options = append(options, dockergoal.ContainerBundleDir(filepath.Dir(loadFile)))
+	}
+
+	var containers []*dockergoal.Container
+	if goalFile != "" {
+		containers, err = dockergoal.ReadGoalFile(goalFile, options...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if saveFile != "" {
+		var ac *dockerclient.AuthConfig
+		if authFile != "" {
+			ac, err = dockerutil.AuthConfigFromFile(authFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		f, err := os.Create(saveFile)
+		if err != nil {
+			return stackerr.Wrap(err)
+		}
+		defer f.Close()
+		images := dockergoal.Images(containers)
+		if err := dockerutil.SaveBundle(docker, f, images, ac); err != nil {
+			return err
+		}
+		log.Printf("saved %d images to %s", len(images), saveFile)
+		return stackerr.Wrap(f.Close())
+	}
+
+	manifest, err := dockerutil.LoadBundleFile(docker, loadFile)
+	if err != nil {
+		return err
+	}
+	for _, i := range manifest.Images {
+		log.Printf("loaded %s", i.Reference)
+	}
+
+	if apply {
+		return dockergoal.ApplyGraph(docker, containers)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Command dockergoal-update recreates the containers of a goal file whose
+// image tag has moved on. It runs once, or repeatedly with -interval, which
+// makes it suitable for cron or as a long running watcher.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/dockerutil/dockergoal"
+	"github.com/facebookgo/dockerutil/registry"
+)
+
+func main() {
+	goalFile := flag.String("goal", "", "goal file with the containers to update")
+	authFile := flag.String("auth", "", "docker config file with registry credentials")
+	interval := flag.Duration("interval", 0, "check for updates at this interval, instead of once")
+	useRegistry := flag.Bool("registry", false, "ask the registry for digests before pulling")
+	flag.Parse()
+
+	if err := run(*goalFile, *authFile, *interval, *useRegistry); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(goalFile, authFile string, interval time.Duration, useRegistry bool) error {
+	if goalFile == "" {
+		return fmt.Errorf("-goal is required")
+	}
+
+	var options []dockergoal.ContainerOption
+	if authFile != "" {
+		ac, err := dockerutil.AuthConfigFromFile(authFile)
+		if err != nil {
+			return err
+		}
+		options = append(options, dockergoal.ContainerAuthConfig(ac))
+	}
+
+	containers, err := dockergoal.ReadGoalFile(goalFile, options...)
+	if err != nil {
+		return err
+	}
+
+	docker, err := dockerutil.BestEffortDockerClient()
+	if err != nil {
+		return err
+	}
+
+	var updateOptions []dockergoal.UpdateOption
+	if useRegistry {
+		var rc registry.Client
+		updateOptions = append(updateOptions, dockergoal.UpdateDigestResolver(rc.Digest))
+	}
+
+	for {
+		updated, err := dockergoal.UpdateGraph(docker, containers, updateOptions...)
+		if err != nil {
+			if interval == 0 {
+				return err
+			}
+			log.Println(err)
+		}
+		for _, name := range updated {
+			log.Printf("updated %s", name)
+		}
+
+		if interval == 0 {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
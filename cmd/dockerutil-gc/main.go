@@ -0,0 +1,60 @@
+// Command dockerutil-gc removes images which are not used by any container,
+// keeping the newest images of each repository and protected ones.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/facebookgo/dockerutil"
+)
+
+func main() {
+	keep := flag.Int("keep", 1, "number of newest images to keep per repository")
+	protect := flag.String("protect", "", "comma separated patterns of images to never remove")
+	dryRun := flag.Bool("dry-run", false, "only report what would be removed")
+	flag.Parse()
+
+	if err := run(*keep, *protect, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(keep int, protect string, dryRun bool) error {
+	docker, err := dockerutil.BestEffortDockerClient()
+	if err != nil {
+		return err
+	}
+
+	o := dockerutil.GCOptions{
+		KeepPerRepository: keep,
+		DryRun:            dryRun,
+	}
+	if protect != "" {
+		o.Protect = strings.Split(protect, ",")
+	}
+
+	result, err := dockerutil.GCImages(docker, o)
+	if err != nil {
+		return err
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, i := range result.Removed {
+		fmt.Printf("%s %s %v (%d bytes)\n", verb, i.Id, i.RepoTags, i.Size)
+	}
+	fmt.Printf("%s %d images, reclaiming %d bytes\n", verb, len(result.Removed), result.ReclaimedBytes)
+	for _, e := range result.Failed {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("failed to remove %d images", len(result.Failed))
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package dockerutil
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// CopyOption configures CopyTo, CopyFSTo and CopyFileTo.
+type CopyOption func(*copyOptions)
+
+type copyOptions struct {
+	owner *[2]int
+}
+
+func newCopyOptions(options []CopyOption) *copyOptions {
+	var o copyOptions
+	for _, f := range options {
+		f(&o)
+	}
+	return &o
+}
+
+// CopyOwner makes the copied files owned by the given user and group IDs in
+// the container, instead of the owner on the host or root for in-memory
+// content.
+func CopyOwner(uid, gid int) CopyOption {
+	return func(o *copyOptions) {
+		o.owner = &[2]int{uid, gid}
+	}
+}
+
+// header applies the options to a tar header.
+func (o *copyOptions) header(h *tar.Header) {
+	if o.owner != nil {
+		h.Uid, h.Gid = o.owner[0], o.owner[1]
+		h.Uname, h.Gname = "", ""
+	}
+}
+
+// CopyTo copies the file or directory at src on the host into the directory
+// dst in the container, which must exist, as "docker cp" does. Modes and
+// ownership are preserved. The container does not need to be running.
+func CopyTo(
+	d *dockerclient.DockerClient,
+	container, src, dst string,
+	options ...CopyOption,
+) error {
+	o := newCopyOptions(options)
+	src = filepath.Clean(src)
+	base := filepath.Dir(src)
+	return putArchive(d, container, dst, func(tw *tar.Writer) error {
+		return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			name, err := filepath.Rel(base, p)
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			return writeTarFile(tw, p, filepath.ToSlash(name), o.header)
+		})
+	})
+}
+
+// CopyFSTo copies the contents of the file system into the directory dst in
+// the container, which must exist. The files are owned by root unless the
+// file system reports an owner, as os.DirFS does.
+func CopyFSTo(
+	d *dockerclient.DockerClient,
+	container string,
+	fsys fs.FS,
+	dst string,
+	options ...CopyOption,
+) error {
+	o := newCopyOptions(options)
+	return putArchive(d, container, dst, func(tw *tar.Writer) error {
+		return fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			if p == "." {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return stackerr.Newf("cannot copy %q, only files and directories are supported", p)
+			}
+			h, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			h.Name = p
+			if info.IsDir() {
+				h.Name += "/"
+			}
+			o.header(h)
+			if err := tw.WriteHeader(h); err != nil {
+				return stackerr.Wrap(err)
+			}
+			if info.IsDir() {
+				return nil
+			}
+			f, err := fsys.Open(p)
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return stackerr.Wrap(err)
+		})
+	})
+}
+
+// CopyFileTo writes the content to the file at dst in the container, which
+// must be an absolute path whose directory exists. The file is owned by root unless CopyOwner says
+// otherwise.
+func CopyFileTo(
+	d *dockerclient.DockerClient,
+	container, dst string,
+	content []byte,
+	mode os.FileMode,
+	options ...CopyOption,
+) error {
+	dir, name := path.Split(dst)
+	if !path.IsAbs(dst) || name == "" {
+		return stackerr.Newf("destination %q must be an absolute path to a file", dst)
+	}
+	o := newCopyOptions(options)
+	return putArchive(d, container, dir, func(tw *tar.Writer) error {
+		h := &tar.Header{
+			Name:     name,
+			Mode:     int64(mode.Perm()),
+			Size:     int64(len(content)),
+			ModTime:  time.Now(),
+			Typeflag: tar.TypeReg,
+		}
+		o.header(h)
+		if err := tw.WriteHeader(h); err != nil {
+			return stackerr.Wrap(err)
+		}
+		_, err := tw.Write(content)
+		return stackerr.Wrap(err)
+	})
+}
+
+// putArchive extracts the tar archive written by f into the directory in the
+// container.
+func putArchive(
+	d *dockerclient.DockerClient,
+	container, dir string,
+	f func(tw *tar.Writer) error,
+) error {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := f(tw)
+		if err == nil {
+			err = stackerr.Wrap(tw.Close())
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	query := url.Values{"path": {dir}}
+	header := http.Header{"Content-Type": {"application/x-tar"}}
+	res, err := apiRequest(d, "PUT", "/containers/"+container+"/archive", query, pr, header)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// CopyFrom copies the file or directory at src in the container into the
+// directory dst on the host, as "docker cp" does. Modes and modification times
+// are preserved, and so is ownership when running as root.
+func CopyFrom(d *dockerclient.DockerClient, container, src, dst string) error {
+	query := url.Values{"path": {src}}
+	res, err := apiRequest(d, "GET", "/containers/"+container+"/archive", query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return extractTar(res.Body, dst)
+}
+
+// writeTarFile writes the file at p on the host to the archive with the given
+// name. The header is passed to fix, if not nil, before it's written.
+func writeTarFile(tw *tar.Writer, p, name string, fix func(*tar.Header)) error {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return stackerr.Wrap(err)
+		}
+	}
+	h, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	h.Name = name
+	if info.IsDir() {
+		h.Name += "/"
+	}
+	if fix != nil {
+		fix(h)
+	}
+	if err := tw.WriteHeader(h); err != nil {
+		return stackerr.Wrap(err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return stackerr.Wrap(err)
+}
+
+// extractTar extracts the archive into the directory. Entries which would end
+// up outside of it are rejected.
+func extractTar(r io.Reader, dir string) error {
+	chown := os.Geteuid() == 0
+	tr := tar.NewReader(r)
+	var dirs []*tar.Header
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stackerr.Wrap(err)
+		}
+
+		name := path.Clean(h.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return stackerr.Newf("archive entry %q is outside of the destination", h.Name)
+		}
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(h.Mode).Perm()
+
+		// a symlink in the archive must not redirect later entries
+		if err := checkInside(dir, filepath.Dir(p)); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return stackerr.Wrap(err)
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := checkInside(dir, p); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(p, 0700); err != nil {
+				return stackerr.Wrap(err)
+			}
+			// permissions are set last, so read only directories can be
+			// filled first
+			dirs = append(dirs, h)
+		case tar.TypeReg:
+			// replace a symlink from the archive rather than writing to
+			// its target
+			if info, err := os.Lstat(p); err == nil && !info.Mode().IsRegular() {
+				if err := os.Remove(p); err != nil {
+					return stackerr.Wrap(err)
+				}
+			}
+			if err := checkInside(dir, p); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return stackerr.Wrap(err)
+			}
+			if err := os.Chmod(p, mode); err != nil {
+				return stackerr.Wrap(err)
+			}
+		case tar.TypeSymlink:
+			os.Remove(p)
+			if err := os.Symlink(h.Linkname, p); err != nil {
+				return stackerr.Wrap(err)
+			}
+		default:
+			// devices, fifos and hard links aren't needed for copying files
+			continue
+		}
+
+		if chown {
+			if err := os.Lchown(p, h.Uid, h.Gid); err != nil {
+				return stackerr.Wrap(err)
+			}
+		}
+		if h.Typeflag != tar.TypeSymlink && h.Typeflag != tar.TypeDir {
+			if err := os.Chtimes(p, h.ModTime, h.ModTime); err != nil {
+				return stackerr.Wrap(err)
+			}
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		h := dirs[i]
+		p := filepath.Join(dir, filepath.FromSlash(path.Clean(h.Name)))
+		if err := os.Chmod(p, os.FileMode(h.Mode).Perm()); err != nil {
+			return stackerr.Wrap(err)
+		}
+		if err := os.Chtimes(p, h.ModTime, h.ModTime); err != nil {
+			return stackerr.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// checkInside returns an error if p, after resolving symlinks, isn't dir or
+// inside of it. If p doesn't exist its closest existing parent is checked,
+// since that's where it would be created.
+func checkInside(dir, p string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	resolved := p
+	for {
+		r, err := filepath.EvalSymlinks(resolved)
+		if err == nil {
+			resolved = r
+			break
+		}
+		parent := filepath.Dir(resolved)
+		if !os.IsNotExist(err) || parent == resolved {
+			return stackerr.Wrap(err)
+		}
+		resolved = parent
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return stackerr.Newf("%q is outside of the destination", p)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package dockerutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+type tarEntry struct {
+	Name     string
+	Mode     int64
+	Uid, Gid int
+	Content  string
+}
+
+func readTarEntries(t *testing.T, r io.Reader) []tarEntry {
+	var entries []tarEntry
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		ensure.Nil(t, err)
+		content, err := ioutil.ReadAll(tr)
+		ensure.Nil(t, err)
+		entries = append(entries, tarEntry{
+			Name:    h.Name,
+			Mode:    h.Mode & 0777,
+			Uid:     h.Uid,
+			Gid:     h.Gid,
+			Content: string(content),
+		})
+	}
+}
+
+// testTarFile is an entry for writeTarEntries.
+type testTarFile struct {
+	header  *tar.Header
+	content string
+}
+
+func writeTarEntries(t *testing.T, files ...testTarFile) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		f.header.Size = int64(len(f.content))
+		ensure.Nil(t, tw.WriteHeader(f.header))
+		io.WriteString(tw, f.content)
+	}
+	ensure.Nil(t, tw.Close())
+	return buf.Bytes()
+}
+
+// archiveServer records the archives put into containers and serves the
+// given one.
+func archiveServer(t *testing.T, put *[]tarEntry, putPath *string, get []byte) *dockerclient.DockerClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ensure.DeepEqual(t, r.URL.Path, "/containers/c/archive")
+		switch r.Method {
+		case "PUT":
+			ensure.DeepEqual(t, r.Header.Get("Content-Type"), "application/x-tar")
+			*putPath = r.URL.Query().Get("path")
+			*put = readTarEntries(t, r.Body)
+		case "GET":
+			ensure.DeepEqual(t, r.URL.Query().Get("path"), "/etc/app")
+			w.Write(get)
+		}
+	}))
+	t.Cleanup(server.Close)
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+	return d
+}
+
+func TestCopyTo(t *testing.T) {
+	dir := writeTestContext(t, map[string]string{"conf/app.conf": "port 80"})
+	defer os.RemoveAll(dir)
+	ensure.Nil(t, os.Chmod(filepath.Join(dir, "conf/app.conf"), 0600))
+
+	var put []tarEntry
+	var putPath string
+	d := archiveServer(t, &put, &putPath, nil)
+	ensure.Nil(t, CopyTo(d, "c", filepath.Join(dir, "conf"), "/etc", CopyOwner(1000, 1000)))
+	ensure.DeepEqual(t, putPath, "/etc")
+	ensure.DeepEqual(t, put, []tarEntry{
+		{Name: "conf/", Mode: 0755, Uid: 1000, Gid: 1000},
+		{Name: "conf/app.conf", Mode: 0600, Uid: 1000, Gid: 1000, Content: "port 80"},
+	})
+}
+
+func TestCopyFSTo(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.conf":     {Data: []byte("port 80"), Mode: 0644},
+		"certs/server": {Data: []byte("secret"), Mode: 0400},
+	}
+	var put []tarEntry
+	var putPath string
+	d := archiveServer(t, &put, &putPath, nil)
+	ensure.Nil(t, CopyFSTo(d, "c", fsys, "/etc/app"))
+	ensure.DeepEqual(t, putPath, "/etc/app")
+	ensure.DeepEqual(t, put, []tarEntry{
+		{Name: "app.conf", Mode: 0644, Content: "port 80"},
+		{Name: "certs/", Mode: 0555},
+		{Name: "certs/server", Mode: 0400, Content: "secret"},
+	})
+}
+
+func TestCopyFileTo(t *testing.T) {
+	var put []tarEntry
+	var putPath string
+	d := archiveServer(t, &put, &putPath, nil)
+	ensure.Nil(t, CopyFileTo(d, "c", "/etc/app/app.conf", []byte("port 80"), 0640, CopyOwner(999, 998)))
+	ensure.DeepEqual(t, putPath, "/etc/app/")
+	ensure.DeepEqual(t, put, []tarEntry{
+		{Name: "app.conf", Mode: 0640, Uid: 999, Gid: 998, Content: "port 80"},
+	})
+}
+
+func TestCopyFileToRelative(t *testing.T) {
+	d := archiveServer(t, nil, nil, nil)
+	err := CopyFileTo(d, "c", "config.json", []byte("{}"), 0644)
+	ensure.Err(t, err, regexp.MustCompile(`destination "config.json" must be an absolute path`))
+	err = CopyFileTo(d, "c", "/etc/app/", []byte("{}"), 0644)
+	ensure.Err(t, err, regexp.MustCompile(`destination "/etc/app/" must be an absolute path to a file`))
+}
+
+func TestCopyFrom(t *testing.T) {
+	modTime := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+	archive := writeTarEntries(t,
+		testTarFile{header: &tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: modTime}},
+		testTarFile{header: &tar.Header{Name: "app/app.conf", Typeflag: tar.TypeReg, Mode: 0600, ModTime: modTime}, content: "port 80"},
+		testTarFile{header: &tar.Header{Name: "app/current", Typeflag: tar.TypeSymlink, Linkname: "app.conf", ModTime: modTime}},
+	)
+	d := archiveServer(t, nil, nil, archive)
+
+	dir, err := ioutil.TempDir("", "dockerutil-copy")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ensure.Nil(t, CopyFrom(d, "c", "/etc/app", dir))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "app/current"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(content), "port 80")
+
+	info, err := os.Stat(filepath.Join(dir, "app/app.conf"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, info.Mode().Perm(), os.FileMode(0600))
+	ensure.True(t, info.ModTime().Equal(modTime))
+
+	info, err = os.Stat(filepath.Join(dir, "app"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, info.Mode().Perm(), os.FileMode(0750))
+}
+
+func TestCopyFromOutside(t *testing.T) {
+	cases := [][]byte{
+		writeTarEntries(t, testTarFile{header: &tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0600}, content: "x"}),
+		writeTarEntries(t,
+			testTarFile{header: &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}},
+			testTarFile{header: &tar.Header{Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0600}, content: "x"},
+		),
+		writeTarEntries(t,
+			testTarFile{header: &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}},
+			testTarFile{header: &tar.Header{Name: "link/new/evil", Typeflag: tar.TypeReg, Mode: 0600}, content: "x"},
+		),
+		writeTarEntries(t,
+			testTarFile{header: &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}},
+			testTarFile{header: &tar.Header{Name: "link", Typeflag: tar.TypeDir, Mode: 0777}},
+		),
+	}
+	for _, archive := range cases {
+		d := archiveServer(t, nil, nil, archive)
+		dir, err := ioutil.TempDir("", "dockerutil-copy")
+		ensure.Nil(t, err)
+		defer os.RemoveAll(dir)
+		err = CopyFrom(d, "c", "/etc/app", dir)
+		ensure.Err(t, err, regexp.MustCompile("outside of the destination"))
+	}
+}
+
+func TestCopyFromReplacesSymlink(t *testing.T) {
+	outside, err := ioutil.TempFile("", "dockerutil-copy-outside")
+	ensure.Nil(t, err)
+	outside.Close()
+	defer os.Remove(outside.Name())
+
+	archive := writeTarEntries(t,
+		testTarFile{header: &tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outside.Name()}},
+		testTarFile{header: &tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0600}, content: "x"},
+	)
+	d := archiveServer(t, nil, nil, archive)
+	dir, err := ioutil.TempDir("", "dockerutil-copy")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ensure.Nil(t, CopyFrom(d, "c", "/etc/app", dir))
+
+	content, err := ioutil.ReadFile(outside.Name())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(content), "")
+
+	info, err := os.Lstat(filepath.Join(dir, "a"))
+	ensure.Nil(t, err)
+	ensure.True(t, info.Mode().IsRegular())
+	content, err = ioutil.ReadFile(filepath.Join(dir, "a"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(content), "x")
+}
@@ -0,0 +1,146 @@
+package dockerutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	defaultDialRetryTimeout  = 30 * time.Second
+	defaultDialRetryInterval = 100 * time.Millisecond
+)
+
+// BindingDialer dials the host address of a container binding. The address is
+// resolved lazily like BindingAddr on the first dial, and resolved again if
+// the container has since been recreated, for example by dockergoal.
+type BindingDialer struct {
+	Docker  dockerclient.Client
+	Name    string
+	Binding string
+
+	// RetryTimeout bounds how long "connection refused" and container not
+	// found errors are retried, which happen while the container is still
+	// starting up or being recreated. A zero value disables retries.
+	RetryTimeout time.Duration
+
+	// RetryInterval is the delay between retries. It defaults to 100ms.
+	RetryInterval time.Duration
+
+	// Dialer is used to establish the connections.
+	Dialer net.Dialer
+
+	mu          sync.Mutex
+	containerID string
+	addr        string
+}
+
+// DialContext connects to the container binding. The network must be a
+// stream network such as "tcp". The given address is ignored, the container
+// binding determines where the connection goes.
+func (b *BindingDialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	interval := b.RetryInterval
+	if interval == 0 {
+		interval = defaultDialRetryInterval
+	}
+	deadline := time.Now().Add(b.RetryTimeout)
+
+	for {
+		conn, err := b.dial(ctx, network)
+		if err == nil {
+			return conn, nil
+		}
+		if !retryDial(err) || time.Now().Add(interval).After(deadline) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, stackerr.Wrap(ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// dial resolves the binding and makes a single connection attempt.
+func (b *BindingDialer) dial(ctx context.Context, network string) (net.Conn, error) {
+	addr, err := b.resolve()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := b.Dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	return conn, nil
+}
+
+// retryDial reports whether a failed dial may succeed later, either because
+// nothing listens on the port yet or because the container is being
+// recreated.
+func retryDial(err error) bool {
+	return stackerr.HasUnderlying(err, func(err error) bool {
+		return err == dockerclient.ErrNotFound || errors.Is(err, syscall.ECONNREFUSED)
+	})
+}
+
+// resolve returns the current address for the binding. The container is
+// inspected on every call, but the address is only looked up again when the
+// container ID has changed since the last call.
+func (b *BindingDialer) resolve() (string, error) {
+	ci, err := b.Docker.InspectContainer(b.Name)
+	if err != nil {
+		return "", stackerr.Wrap(err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ci.Id == b.containerID && b.addr != "" {
+		return b.addr, nil
+	}
+
+	addr, err := bindingAddr(b.Docker, ci, b.Name, b.Binding)
+	if err != nil {
+		return "", err
+	}
+	b.containerID = ci.Id
+	b.addr = addr
+	return addr, nil
+}
+
+// BindingDialContext returns a DialContext function which connects to the
+// container binding, retrying for up to 30 seconds while the container is
+// starting up or being recreated. It is suitable for http.Transport.DialContext and similar.
+func BindingDialContext(
+	d dockerclient.Client,
+	name, binding string,
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	b := &BindingDialer{
+		Docker:       d,
+		Name:         name,
+		Binding:      binding,
+		RetryTimeout: defaultDialRetryTimeout,
+	}
+	return b.DialContext
+}
+
+// BindingHTTPClient returns a http.Client which sends all requests to the
+// container binding. The host in request URLs is used for the Host header
+// only, for example "http://my-service/health" works regardless of where the
+// container port is published.
+func BindingHTTPClient(d dockerclient.Client, name, binding string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:     BindingDialContext(d, name, binding),
+			MaxIdleConns:    10,
+			IdleConnTimeout: 90 * time.Second,
+		},
+	}
+}
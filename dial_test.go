@@ -0,0 +1,205 @@
+package dockerutil
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+func TestBindingHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + r.URL.Path))
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	ensure.Nil(t, err)
+
+	var inspects int
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			inspects++
+			ensure.DeepEqual(t, name, "web")
+			ci := &dockerclient.ContainerInfo{Id: "web-id"}
+			ci.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{
+				"80/tcp": {{HostPort: port}},
+			}
+			return ci, nil
+		},
+	}
+	res, err := BindingHTTPClient(client, "web", "80/tcp").Get("http://my-service/health")
+	ensure.Nil(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(body), "my-service/health")
+	ensure.DeepEqual(t, inspects, 1)
+}
+
+func TestBindingDialerNoHostPort(t *testing.T) {
+	var inspects int
+	b := &BindingDialer{
+		Docker: &mockClient{
+			inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+				inspects++
+				ci := &dockerclient.ContainerInfo{Id: "web-id"}
+				ci.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{"80/tcp": {}}
+				return ci, nil
+			},
+		},
+		Name:    "web",
+		Binding: "80/tcp",
+	}
+	_, err := b.DialContext(context.Background(), "tcp", "")
+	ensure.Err(t, err, regexp.MustCompile(`container "web" has no host port for binding "80/tcp"`))
+	ensure.DeepEqual(t, inspects, 1)
+}
+
+func TestBindingDialerUnknownContainer(t *testing.T) {
+	b := &BindingDialer{
+		Docker: &mockClient{
+			inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+				return nil, dockerclient.ErrNotFound
+			},
+		},
+		Name:    "other",
+		Binding: "80/tcp",
+	}
+	_, err := b.DialContext(context.Background(), "tcp", "")
+	ensure.True(t, stackerr.HasUnderlying(err, stackerr.Equals(dockerclient.ErrNotFound)))
+}
+
+func TestBindingDialerRetriesRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ensure.Nil(t, err)
+	addr := l.Addr().String()
+	_, port, err := net.SplitHostPort(addr)
+	ensure.Nil(t, err)
+	ensure.Nil(t, l.Close())
+
+	var inspects int
+	b := &BindingDialer{
+		Docker: &mockClient{
+			inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+				inspects++
+				ci := &dockerclient.ContainerInfo{Id: "web-id"}
+				ci.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{
+					"80/tcp": {{HostPort: port}},
+				}
+				return ci, nil
+			},
+		},
+		Name:          "web",
+		Binding:       "80/tcp",
+		RetryTimeout:  50 * time.Millisecond,
+		RetryInterval: 10 * time.Millisecond,
+	}
+	_, err = b.DialContext(context.Background(), "tcp", "")
+	ensure.True(t, stackerr.HasUnderlying(err, func(err error) bool {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}))
+	ensure.True(t, inspects > 1)
+
+	// Something starts listening while the dialer is still retrying.
+	b.RetryTimeout = 10 * time.Second
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer l.Close()
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := b.DialContext(context.Background(), "tcp", "")
+	ensure.Nil(t, err)
+	conn.Close()
+}
+
+func TestBindingDialerRetriesNotFound(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ensure.Nil(t, err)
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	ensure.Nil(t, err)
+
+	var inspects int
+	b := &BindingDialer{
+		Docker: &mockClient{
+			inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+				inspects++
+				if inspects < 3 {
+					return nil, dockerclient.ErrNotFound
+				}
+				ci := &dockerclient.ContainerInfo{Id: "web-id"}
+				ci.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{
+					"80/tcp": {{HostPort: port}},
+				}
+				return ci, nil
+			},
+		},
+		Name:          "web",
+		Binding:       "80/tcp",
+		RetryTimeout:  10 * time.Second,
+		RetryInterval: time.Millisecond,
+	}
+	conn, err := b.DialContext(context.Background(), "tcp", "")
+	ensure.Nil(t, err)
+	conn.Close()
+	ensure.DeepEqual(t, inspects, 3)
+}
+
+func TestBindingDialerRecreated(t *testing.T) {
+	var ports []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		ensure.Nil(t, err)
+		defer l.Close()
+		_, port, err := net.SplitHostPort(l.Addr().String())
+		ensure.Nil(t, err)
+		ports = append(ports, port)
+	}
+
+	id := "web-1"
+	b := &BindingDialer{
+		Docker: &mockClient{
+			inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+				port := ports[0]
+				if id == "web-2" {
+					port = ports[1]
+				}
+				ci := &dockerclient.ContainerInfo{Id: id}
+				ci.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{
+					"80/tcp": {{HostPort: port}},
+				}
+				return ci, nil
+			},
+		},
+		Name:    "web",
+		Binding: "80/tcp",
+	}
+	dialedPort := func() string {
+		conn, err := b.DialContext(context.Background(), "tcp", "")
+		ensure.Nil(t, err)
+		defer conn.Close()
+		_, port, err := net.SplitHostPort(conn.RemoteAddr().String())
+		ensure.Nil(t, err)
+		return port
+	}
+	ensure.DeepEqual(t, dialedPort(), ports[0])
+	ensure.DeepEqual(t, dialedPort(), ports[0])
+	id = "web-2"
+	ensure.DeepEqual(t, dialedPort(), ports[1])
+}
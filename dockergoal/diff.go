@@ -0,0 +1,180 @@
+package dockergoal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// The fields compared between an existing container and its desired state.
+const (
+	FieldImage = "image"
+	FieldDNS   = "dns"
+	FieldCmd   = "cmd"
+	FieldEnv   = "env"
+	FieldBinds = "binds"
+	FieldPorts = "ports"
+
+	FieldExposedPorts = "exposed_ports"
+)
+
+// A FieldDiff is a field in which an existing container differs from its
+// desired state. Images are compared by ID, and Desired is empty for the image
+// if the desired image is not present.
+type FieldDiff struct {
+	Field   string   `json:"field"`
+	Current []string `json:"current"`
+	Desired []string `json:"desired"`
+}
+
+// A Diff lists the fields in which an existing container differs from its
+// desired state. The values of environment variables are redacted when it's
+// marshalled to JSON, since they often hold secrets.
+type Diff struct {
+	Container string `json:"container"`
+
+	// Image is the desired image of the container.
+	Image string `json:"image"`
+
+	Fields []FieldDiff `json:"fields"`
+}
+
+// diffJSON is a Diff without the redacting MarshalJSON.
+type diffJSON Diff
+
+// MarshalJSON marshals the diff with the values of environment variables
+// redacted.
+func (d Diff) MarshalJSON() ([]byte, error) {
+	return marshalDiff(&d, false)
+}
+
+func marshalDiff(d *Diff, showEnv bool) ([]byte, error) {
+	if !showEnv {
+		d = redactDiff(d)
+	}
+	shown := diffJSON(*d)
+	if shown.Fields == nil {
+		shown.Fields = []FieldDiff{}
+	}
+	return json.Marshal(&shown)
+}
+
+const redacted = "(redacted)"
+
+// redactDiff returns a copy of the diff with the values of the environment
+// variables replaced.
+func redactDiff(d *Diff) *Diff {
+	r := *d
+	r.Fields = make([]FieldDiff, len(d.Fields))
+	for i, f := range d.Fields {
+		if f.Field == FieldEnv {
+			f.Current = redactEnv(f.Current)
+			f.Desired = redactEnv(f.Desired)
+		}
+		r.Fields[i] = f
+	}
+	return &r
+}
+
+func redactEnv(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	res := make([]string, len(env))
+	for i, v := range env {
+		res[i] = fieldKey(FieldEnv, v) + "=" + redacted
+	}
+	return res
+}
+
+// Empty reports if the existing container has the desired state.
+func (d *Diff) Empty() bool {
+	return len(d.Fields) == 0
+}
+
+// Field returns the difference in the named field, or nil if it doesn't
+// differ.
+func (d *Diff) Field(name string) *FieldDiff {
+	for i := range d.Fields {
+		if d.Fields[i].Field == name {
+			return &d.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Reasons describes each of the differing fields. The values of environment
+// variables are redacted.
+func (d *Diff) Reasons() []string {
+	return redactDiff(d).reasons()
+}
+
+func (d *Diff) reasons() []string {
+	reasons := make([]string, len(d.Fields))
+	for i, f := range d.Fields {
+		reasons[i] = d.reason(f)
+	}
+	return reasons
+}
+
+func (d *Diff) reason(f FieldDiff) string {
+	switch f.Field {
+	case FieldImage:
+		if len(f.Desired) == 0 {
+			return fmt.Sprintf(
+				"running with image %q but desired image %q is not present",
+				f.Current[0],
+				d.Image,
+			)
+		}
+		return fmt.Sprintf(
+			"running with image %q but desired image is %q with id %q",
+			f.Current[0],
+			d.Image,
+			f.Desired[0],
+		)
+	case FieldDNS:
+		return fmt.Sprintf("running with DNS %v but desired DNS is %v", f.Current, f.Desired)
+	case FieldCmd:
+		return fmt.Sprintf("running with command %v but desired command is %v", f.Current, f.Desired)
+	case FieldBinds:
+		return fmt.Sprintf("running with volumes %v but desired volumes are %v", f.Current, f.Desired)
+	case FieldPorts:
+		return fmt.Sprintf("running with ports %v but desired ports are %v", f.Current, f.Desired)
+	case FieldExposedPorts:
+		return fmt.Sprintf("running with exposed ports %v but desired exposed ports are %v", f.Current, f.Desired)
+	}
+	return fmt.Sprintf("running with %s %v but desired %s is %v", f.Field, f.Current, f.Field, f.Desired)
+}
+
+// A MismatchError is returned when an existing container differs from its
+// desired state and ContainerRemoveExisting wasn't specified.
+type MismatchError struct {
+	Diff *Diff
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("container %q %s", e.Diff.Container, strings.Join(e.Diff.Reasons(), "; "))
+}
+
+// Diff compares the existing container with its desired state, without
+// changing anything. Like Plan it uses the image which is present rather than
+// pulling it. It returns dockerclient.ErrNotFound if the container doesn't
+// exist.
+func (c *Container) Diff(docker dockerclient.Client) (*Diff, error) {
+	image, imageID, err := c.localImage(docker)
+	if err != nil {
+		return nil, err
+	}
+	ci, err := docker.InspectContainer(c.name)
+	if err != nil {
+		if err == dockerclient.ErrNotFound {
+			return nil, err
+		}
+		return nil, stackerr.Wrap(err)
+	}
+	return c.diff(ci, image, imageID), nil
+}
@@ -0,0 +1,189 @@
+package dockergoal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func TestDiffListsAllFields(t *testing.T) {
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "foo",
+			Cmd:   []string{"serve"},
+			Env:   []string{"A=2"},
+		}),
+		ContainerHostConfig(&dockerclient.HostConfig{
+			Dns:   []string{"8.8.8.8"},
+			Binds: []string{"/data:/var/lib/data"},
+		}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{
+				Image:      "old",
+				Config:     &dockerclient.ContainerConfig{Cmd: []string{"run"}, Env: []string{"A=1"}},
+				HostConfig: &dockerclient.HostConfig{Dns: []string{"1.1.1.1"}},
+				Volumes:    map[string]string{"/var/lib/data": "/old"},
+			}, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{RepoTags: []string{"foo:latest"}, Id: "foo-id"}}, nil
+		},
+	}
+	diff, err := container.Diff(client)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, diff, &Diff{
+		Container: "x",
+		Image:     "foo",
+		Fields: []FieldDiff{
+			{Field: FieldImage, Current: []string{"old"}, Desired: []string{"foo-id"}},
+			{Field: FieldDNS, Current: []string{"1.1.1.1"}, Desired: []string{"8.8.8.8"}},
+			{Field: FieldCmd, Current: []string{"run"}, Desired: []string{"serve"}},
+			{Field: FieldEnv, Current: []string{"A=1"}, Desired: []string{"A=2"}},
+			{Field: FieldBinds, Current: []string{"/old:/var/lib/data"}, Desired: []string{"/data:/var/lib/data"}},
+		},
+	})
+	ensure.False(t, diff.Empty())
+	ensure.DeepEqual(t, diff.Field(FieldEnv), &diff.Fields[3])
+	ensure.True(t, diff.Field("other") == nil)
+	ensure.DeepEqual(t, diff.Reasons(), []string{
+		`running with image "old" but desired image is "foo" with id "foo-id"`,
+		`running with DNS [1.1.1.1] but desired DNS is [8.8.8.8]`,
+		`running with command [run] but desired command is [serve]`,
+		`running with env [A=(redacted)] but desired env is [A=(redacted)]`,
+		`running with volumes [/old:/var/lib/data] but desired volumes are [/data:/var/lib/data]`,
+	})
+}
+
+func TestDiffEmpty(t *testing.T) {
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "foo",
+			Cmd:   []string{"serve"},
+			Env:   []string{"A=2"},
+		}),
+		ContainerHostConfig(&dockerclient.HostConfig{
+			Dns:   []string{"8.8.8.8"},
+			Binds: []string{"/data:/var/lib/data"},
+		}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{
+				Image: "foo-id",
+				Config: &dockerclient.ContainerConfig{
+					Cmd: []string{"/entrypoint", "serve"},
+					Env: []string{"PATH=/bin", "A=2"},
+				},
+				HostConfig: &dockerclient.HostConfig{Dns: []string{"8.8.8.8"}},
+				Volumes:    map[string]string{"/var/lib/data": "/data"},
+			}, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{RepoTags: []string{"foo:latest"}, Id: "foo-id"}}, nil
+		},
+	}
+	diff, err := container.Diff(client)
+	ensure.Nil(t, err)
+	ensure.True(t, diff.Empty())
+}
+
+func TestDiffImageNotPresent(t *testing.T) {
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Image: "old"}, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{RepoTags: []string{"foo:latest"}, Id: "foo-id"}}, nil
+		},
+	}
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "bar"}),
+	)
+	ensure.Nil(t, err)
+	diff, err := container.Diff(client)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, diff.Fields, []FieldDiff{
+		{Field: FieldImage, Current: []string{"old"}},
+	})
+	ensure.DeepEqual(t, diff.Reasons(), []string{
+		`running with image "old" but desired image "bar" is not present`,
+	})
+}
+
+func TestDiffNotFound(t *testing.T) {
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "foo",
+			Cmd:   []string{"serve"},
+			Env:   []string{"A=2"},
+		}),
+		ContainerHostConfig(&dockerclient.HostConfig{
+			Dns:   []string{"8.8.8.8"},
+			Binds: []string{"/data:/var/lib/data"},
+		}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return nil, dockerclient.ErrNotFound
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{RepoTags: []string{"foo:latest"}, Id: "foo-id"}}, nil
+		},
+	}
+	_, err = container.Diff(client)
+	ensure.True(t, err == dockerclient.ErrNotFound)
+}
+
+func TestApplyMismatchError(t *testing.T) {
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "foo",
+			Cmd:   []string{"serve"},
+			Env:   []string{"A=2"},
+		}),
+		ContainerHostConfig(&dockerclient.HostConfig{
+			Dns:   []string{"8.8.8.8"},
+			Binds: []string{"/data:/var/lib/data"},
+		}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{
+				Image:      "foo-id",
+				Config:     &dockerclient.ContainerConfig{Cmd: []string{"serve"}, Env: []string{"A=1"}},
+				HostConfig: &dockerclient.HostConfig{Dns: []string{"1.1.1.1"}},
+				Volumes:    map[string]string{"/var/lib/data": "/data"},
+			}, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{RepoTags: []string{"foo:latest"}, Id: "foo-id"}}, nil
+		},
+	}
+	err = container.Apply(client)
+
+	var mismatch *MismatchError
+	ensure.True(t, errors.As(err, &mismatch))
+	ensure.DeepEqual(t, mismatch.Diff.Fields, []FieldDiff{
+		{Field: FieldDNS, Current: []string{"1.1.1.1"}, Desired: []string{"8.8.8.8"}},
+		{Field: FieldEnv, Current: []string{"A=1"}, Desired: []string{"A=2"}},
+	})
+	ensure.DeepEqual(
+		t,
+		err.Error(),
+		`container "x" running with DNS [1.1.1.1] but desired DNS is [8.8.8.8]; `+
+			`running with env [A=(redacted)] but desired env is [A=(redacted)]`,
+	)
+}
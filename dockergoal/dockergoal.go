@@ -5,7 +5,12 @@ package dockergoal
 
 import (
 	"errors"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/facebookgo/dockerutil"
 	"github.com/facebookgo/stackerr"
@@ -23,6 +28,21 @@ type Container struct {
 	hostConfig          *dockerclient.HostConfig
 	removeExisting      bool
 	forceRemoveExisting bool
+	requireDigest       bool
+	pullPolicy          dockerutil.PullPolicy
+	pullPolicySet       bool
+	pullProgress        dockerutil.ProgressFunc
+	imageIndex          *dockerutil.ImageIndex
+	bundleDir           string
+	rewriter            *dockerutil.Rewriter
+	logf                func(format string, args ...interface{})
+	build               *dockerutil.BuildOptions
+	builtImage          string
+	readyLog            *readyLog
+	seeds               []seed
+	stopSignal          string
+	stopGracePeriod     time.Duration
+	stopGracePeriodSet  bool
 	authConfig          *dockerclient.AuthConfig
 	afterCreate         func(string) error
 }
@@ -42,6 +62,37 @@ func NewContainer(options ...ContainerOption) (*Container, error) {
 	if c.name == "" {
 		return nil, errNameMissing
 	}
+	if c.build != nil {
+		if c.requireDigest {
+			return nil, stackerr.Newf("container %q is built and cannot require a digest", c.name)
+		}
+		if c.containerConfig == nil {
+			c.containerConfig = &dockerclient.ContainerConfig{}
+		}
+		if c.containerConfig.Image == "" {
+			// repositories must be lowercase, unlike container names
+			repository := strings.ToLower(c.name)
+			if _, err := dockerutil.ParseReference(repository); err != nil {
+				return nil, stackerr.Newf(
+					"container %q cannot be used as the repository of its built image, "+
+						"specify one using the Image of ContainerConfig",
+					c.name,
+				)
+			}
+			c.build.Repository = repository
+		} else {
+			ref, err := dockerutil.ParseReference(c.containerConfig.Image)
+			if err != nil {
+				return nil, err
+			}
+			c.build.Repository = ref.FamiliarName()
+		}
+	}
+	if c.requireDigest {
+		if err := c.checkDigestPinned(); err != nil {
+			return nil, err
+		}
+	}
 	return &c, nil
 }
 
@@ -75,6 +126,16 @@ func ContainerForceRemoveExisting() ContainerOption {
 	}
 }
 
+// ContainerRequireDigest requires the image to be pinned to a digest, as in
+// "redis@sha256:...". NewContainer fails if it isn't. After a new container is
+// created the digest of its image is verified to match the requested one.
+func ContainerRequireDigest() ContainerOption {
+	return func(c *Container) error {
+		c.requireDigest = true
+		return nil
+	}
+}
+
 // ContainerConfig specifies the container configuration.
 func ContainerConfig(config *dockerclient.ContainerConfig) ContainerOption {
 	return func(c *Container) error {
@@ -100,6 +161,131 @@ func ContainerAuthConfig(ac *dockerclient.AuthConfig) ContainerOption {
 	}
 }
 
+// ContainerPullPolicy specifies when the image is pulled. The default is
+// dockerutil.PullIfNotPresent. With dockerutil.PullAlways a moving tag such as
+// "latest" is refreshed on every Apply, and the container is recreated if the
+// tag now points to a different image.
+func ContainerPullPolicy(p dockerutil.PullPolicy) ContainerOption {
+	return func(c *Container) error {
+		c.pullPolicy = p
+		c.pullPolicySet = true
+		return nil
+	}
+}
+
+// ContainerPullProgress specifies a function which receives progress reports
+// while the image is being pulled.
+func ContainerPullProgress(f dockerutil.ProgressFunc) ContainerOption {
+	return func(c *Container) error {
+		c.pullProgress = f
+		return nil
+	}
+}
+
+// ContainerImageIndex specifies an index used to identify the desired image,
+// instead of listing all images on every Apply. The same index can and
+// should be shared by all the containers of a graph.
+func ContainerImageIndex(x *dockerutil.ImageIndex) ContainerOption {
+	return func(c *Container) error {
+		c.imageIndex = x
+		return nil
+	}
+}
+
+// ContainerBundleDir specifies a directory of image bundles, as written by
+// dockerutil.SaveBundle, which are used instead of pulling from a registry.
+func ContainerBundleDir(dir string) ContainerOption {
+	return func(c *Container) error {
+		c.bundleDir = dir
+		return nil
+	}
+}
+
+// ContainerLogf specifies a function which receives messages about the image,
+// such as files in the bundle directory which aren't bundles. Nothing is
+// logged by default.
+func ContainerLogf(logf func(format string, args ...interface{})) ContainerOption {
+	return func(c *Container) error {
+		c.logf = logf
+		return nil
+	}
+}
+
+// ContainerRewriter specifies rewrite rules for the image, for example to
+// pull it from a mirror. The existing container is compared against the
+// rewritten image.
+func ContainerRewriter(r *dockerutil.Rewriter) ContainerOption {
+	return func(c *Container) error {
+		c.rewriter = r
+		return nil
+	}
+}
+
+// ContainerBuild builds the image from the Dockerfile in the context
+// directory instead of using a prebuilt one. The dockerfile is relative to the
+// context and defaults to "Dockerfile". The image is tagged with the content
+// hash of the context, and named after the lowercased container unless the
+// ContainerConfig specifies an Image, in which case its name is used. The
+// image is only rebuilt when the hash changes, which also changes the desired
+// image, so the container is recreated along with ContainerRemoveExisting.
+// Building requires a *dockerclient.DockerClient.
+func ContainerBuild(context, dockerfile string) ContainerOption {
+	return func(c *Container) error {
+		c.build = &dockerutil.BuildOptions{
+			Context:    context,
+			Dockerfile: dockerfile,
+		}
+		return nil
+	}
+}
+
+// readyLog is the log output a container must write before it's ready.
+type readyLog struct {
+	re      *regexp.Regexp
+	n       int
+	timeout time.Duration
+}
+
+// ContainerWaitForLog makes Apply wait after starting the container until the
+// regular expression matched n lines of its logs, as with
+// dockerutil.WaitForLog. ApplyGraph only starts the containers linking to it
+// once it's ready. Apply fails if the container stops or the timeout expires
+// first, leaving the container in place to be inspected.
+func ContainerWaitForLog(re *regexp.Regexp, n int, timeout time.Duration) ContainerOption {
+	return func(c *Container) error {
+		c.readyLog = &readyLog{re: re, n: n, timeout: timeout}
+		return nil
+	}
+}
+
+// A seed copies content into a newly created container.
+type seed func(d *dockerclient.DockerClient, id string) error
+
+// ContainerSeedFile writes the content to the file at path in the container
+// after it's created and before it's first started, for example to provide a
+// configuration file without building an image for it. The directory must
+// exist in the image. Seeding requires a *dockerclient.DockerClient.
+func ContainerSeedFile(path string, content []byte, mode os.FileMode) ContainerOption {
+	return func(c *Container) error {
+		c.seeds = append(c.seeds, func(d *dockerclient.DockerClient, id string) error {
+			return dockerutil.CopyFileTo(d, id, path, content, mode)
+		})
+		return nil
+	}
+}
+
+// ContainerSeedFS copies the file system into the directory dir in the
+// container after it's created and before it's first started. The directory
+// must exist in the image. Seeding requires a *dockerclient.DockerClient.
+func ContainerSeedFS(dir string, fsys fs.FS) ContainerOption {
+	return func(c *Container) error {
+		c.seeds = append(c.seeds, func(d *dockerclient.DockerClient, id string) error {
+			return dockerutil.CopyFSTo(d, id, fsys, dir)
+		})
+		return nil
+	}
+}
+
 // ContainerAfterCreate specifies a function which is invoked when a new
 // container is created. It is not called if an existing running container with
 // the desired state was found.
@@ -113,6 +299,16 @@ func ContainerAfterCreate(f func(containerID string) error) ContainerOption {
 // Apply creates the container, possibly removing it as necessary based on the
 // container options that were set.
 func (c *Container) Apply(docker dockerclient.Client) error {
+	return c.apply(docker, c.pullPolicy)
+}
+
+func (c *Container) apply(docker dockerclient.Client, pullPolicy dockerutil.PullPolicy) error {
+	if c.build != nil {
+		if err := c.buildImage(docker); err != nil {
+			return err
+		}
+	}
+
 	ci, err := docker.InspectContainer(c.name)
 	createIt := false
 
@@ -137,6 +333,11 @@ func (c *Container) Apply(docker dockerclient.Client) error {
 			if err != nil {
 				return err
 			}
+			// checking the existing container already pulled the image if
+			// the policy asked for it
+			if pullPolicy == dockerutil.PullAlways {
+				pullPolicy = dockerutil.PullIfNotPresent
+			}
 			if ok {
 				// existing container is good
 				if ci.State.Running {
@@ -156,24 +357,72 @@ func (c *Container) Apply(docker dockerclient.Client) error {
 
 	// container needs to be created
 	if createIt {
-		_, err := dockerutil.CreateWithPull(docker, c.containerConfig, c.name, c.authConfig)
+		ci, err = c.create(docker, pullPolicy)
 		if err != nil {
 			return err
 		}
+	}
 
-		ci, err = docker.InspectContainer(c.name)
+	return c.start(docker, ci, createIt)
+}
+
+// create creates the container and prepares it to be started.
+func (c *Container) create(
+	docker dockerclient.Client,
+	pullPolicy dockerutil.PullPolicy,
+) (*dockerclient.ContainerInfo, error) {
+	_, err := dockerutil.CreateWithPull(
+		docker,
+		c.config(),
+		c.name,
+		c.authConfig,
+		c.pullOptions(pullPolicy)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ci, err := docker.InspectContainer(c.name)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	if c.requireDigest {
+		err := dockerutil.VerifyImageDigest(
+			docker,
+			ci.Image,
+			c.containerConfig.Image,
+			c.pullOptions(pullPolicy)...,
+		)
 		if err != nil {
-			return stackerr.Wrap(err)
+			docker.RemoveContainer(ci.Id, true, false)
+			return nil, err
 		}
 	}
 
-	// start the container
-	err = docker.StartContainer(ci.Id, c.hostConfig)
+	if err := c.seed(docker, ci.Id); err != nil {
+		docker.RemoveContainer(ci.Id, true, false)
+		return nil, err
+	}
+	return ci, nil
+}
+
+// start starts the container and waits until it's ready. The after create
+// hook is invoked if the container was just created.
+func (c *Container) start(docker dockerclient.Client, ci *dockerclient.ContainerInfo, created bool) error {
+	err := docker.StartContainer(ci.Id, c.hostConfig)
 	if err != nil {
 		return stackerr.Wrap(err)
 	}
 
-	if createIt && c.afterCreate != nil {
+	if c.readyLog != nil {
+		err := dockerutil.WaitForLog(docker, ci.Id, c.readyLog.re, c.readyLog.n, c.readyLog.timeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	if created && c.afterCreate != nil {
 		if err := c.afterCreate(ci.Id); err != nil {
 			docker.RemoveContainer(ci.Id, true, false)
 			return stackerr.Wrap(err)
@@ -183,51 +432,148 @@ func (c *Container) Apply(docker dockerclient.Client) error {
 	return nil
 }
 
-func (c *Container) checkExisting(docker dockerclient.Client, current *dockerclient.ContainerInfo) (bool, error) {
-	if equal, err := c.checkExistingImage(docker, current); !equal || err != nil {
-		return false, err
+// buildImage builds the image of the container, if it doesn't already exist.
+func (c *Container) buildImage(docker dockerclient.Client) error {
+	d, ok := docker.(*dockerclient.DockerClient)
+	if !ok {
+		return stackerr.Newf("container %q is built, which requires a *dockerclient.DockerClient", c.name)
 	}
-	if equal, err := c.checkExistingDNS(current); !equal || err != nil {
-		return false, err
+	image, err := dockerutil.BuildImage(d, *c.build)
+	if err != nil {
+		return err
 	}
-	if equal, err := c.checkExistingCmd(current); !equal || err != nil {
-		return false, err
+	c.builtImage = image
+	return nil
+}
+
+// seed copies the seeds into the newly created container.
+func (c *Container) seed(docker dockerclient.Client, id string) error {
+	if len(c.seeds) == 0 {
+		return nil
 	}
-	if equal, err := c.checkExistingEnv(current); !equal || err != nil {
-		return false, err
+	d, ok := docker.(*dockerclient.DockerClient)
+	if !ok {
+		return stackerr.Newf("container %q is seeded, which requires a *dockerclient.DockerClient", c.name)
 	}
-	if equal, err := c.checkExistingBinds(current); !equal || err != nil {
-		return false, err
+	for _, s := range c.seeds {
+		if err := s(d, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// config returns the container configuration, with the image replaced by the
+// built one if the image is built.
+func (c *Container) config() *dockerclient.ContainerConfig {
+	if c.builtImage == "" {
+		return c.containerConfig
 	}
-	// we're running with the desired configuration
-	return true, nil
+	config := *c.containerConfig
+	config.Image = c.builtImage
+	return &config
 }
 
-func (c *Container) checkExistingImage(docker dockerclient.Client, current *dockerclient.ContainerInfo) (bool, error) {
+func (c *Container) pullOptions(policy dockerutil.PullPolicy) []dockerutil.PullOption {
+	// built images only exist locally
+	if c.build != nil {
+		policy = dockerutil.PullNever
+	}
+	return []dockerutil.PullOption{
+		dockerutil.WithPullPolicy(policy),
+		dockerutil.WithPullProgress(c.pullProgress),
+		dockerutil.WithImageIndex(c.imageIndex),
+		dockerutil.WithBundleDir(c.bundleDir),
+		dockerutil.WithRewriter(c.rewriter),
+		dockerutil.WithLogf(c.logf),
+	}
+}
+
+func (c *Container) checkDigestPinned() error {
+	if c.containerConfig == nil {
+		return stackerr.Newf("container %q requires a digest but has no image", c.name)
+	}
+	ref, err := dockerutil.ParseReference(c.containerConfig.Image)
+	if err != nil {
+		return err
+	}
+	if ref.Digest == "" {
+		return stackerr.Newf(
+			"container %q requires a digest but image %q is not pinned to one",
+			c.name,
+			c.containerConfig.Image,
+		)
+	}
+	return nil
+}
+
+func (c *Container) checkExisting(docker dockerclient.Client, current *dockerclient.ContainerInfo) (bool, error) {
 	// image comparison is by ID, so we need to find the ID of our desired image
-	desiredImageID, err := dockerutil.ImageID(docker, c.containerConfig.Image, c.authConfig)
+	desiredImageID, err := dockerutil.ImageID(
+		docker,
+		c.config().Image,
+		c.authConfig,
+		c.pullOptions(c.pullPolicy)...,
+	)
 	if err != nil {
 		return false, err
 	}
-	if current.Image != desiredImageID {
-		// if we aren't allowed to remove the existing container, consider this a failure
-		if !c.removeExisting {
-			return false, stackerr.Newf(
-				"container %q running with image %q but desired image is %q with id %q",
-				c.name,
-				current.Image,
-				c.containerConfig.Image,
-				desiredImageID,
-			)
+	return c.acceptExisting(c.diff(current, c.config().Image, desiredImageID))
+}
+
+// acceptExisting reports if the existing container with the given diff can be
+// kept. If it can't and we aren't allowed to remove it, a MismatchError is
+// returned.
+func (c *Container) acceptExisting(d *Diff) (bool, error) {
+	if d.Empty() {
+		// we're running with the desired configuration
+		return true, nil
+	}
+
+	// if we aren't allowed to remove the existing container, consider this a failure
+	if !c.removeExisting {
+		return false, &MismatchError{Diff: d}
+	}
+
+	// trigger removing the existing container and starting a new one
+	return false, nil
+}
+
+// diff compares the existing container with the desired state. An empty
+// desiredImageID means the desired image is not present.
+func (c *Container) diff(
+	current *dockerclient.ContainerInfo,
+	desiredImage, desiredImageID string,
+) *Diff {
+	d := &Diff{Container: c.name, Image: desiredImage}
+	for _, f := range []*FieldDiff{
+		checkExistingImage(current, desiredImageID),
+		c.checkExistingDNS(current),
+		c.checkExistingCmd(current),
+		c.checkExistingEnv(current),
+		c.checkExistingBinds(current),
+		c.checkExistingPorts(current),
+		c.checkExistingExposedPorts(current),
+	} {
+		if f != nil {
+			d.Fields = append(d.Fields, *f)
 		}
+	}
+	return d
+}
 
-		// trigger removing the existing container and starting a new one
-		return false, nil
+func checkExistingImage(current *dockerclient.ContainerInfo, desiredImageID string) *FieldDiff {
+	if current.Image != desiredImageID {
+		f := &FieldDiff{Field: FieldImage, Current: []string{current.Image}}
+		if desiredImageID != "" {
+			f.Desired = []string{desiredImageID}
+		}
+		return f
 	}
-	return true, nil
+	return nil
 }
 
-func (c *Container) checkExistingDNS(current *dockerclient.ContainerInfo) (bool, error) {
+func (c *Container) checkExistingDNS(current *dockerclient.ContainerInfo) *FieldDiff {
 	var currentDNS, desiredDNS []string
 	if c.hostConfig != nil {
 		desiredDNS = c.hostConfig.Dns
@@ -236,85 +582,92 @@ func (c *Container) checkExistingDNS(current *dockerclient.ContainerInfo) (bool,
 		currentDNS = current.HostConfig.Dns
 	}
 	if !equalStrSlice(currentDNS, desiredDNS) {
-		// if we aren't allowed to remove the existing container, consider this a failure
-		if !c.removeExisting {
-			return false, stackerr.Newf(
-				"container %q running with DNS %v but desired DNS is %v",
-				c.name,
-				currentDNS,
-				desiredDNS,
-			)
-		}
-
-		// trigger removing the existing container and starting a new one
-		return false, nil
+		return &FieldDiff{Field: FieldDNS, Current: currentDNS, Desired: desiredDNS}
 	}
-	return true, nil
+	return nil
 }
 
-func (c *Container) checkExistingCmd(current *dockerclient.ContainerInfo) (bool, error) {
+func (c *Container) checkExistingCmd(current *dockerclient.ContainerInfo) *FieldDiff {
+	var currentCmd []string
+	if current.Config != nil {
+		currentCmd = current.Config.Cmd
+	}
 	// we only check the tail of the current command matches because it includes
 	// the entrypoint defined in the container dockerfile as well.
-	if !hasSuffixStrSlice(current.Config.Cmd, c.containerConfig.Cmd) {
-		// if we aren't allowed to remove the existing container, consider this a failure
-		if !c.removeExisting {
-			return false, stackerr.Newf(
-				"container %q running with command %v but desired command is %v",
-				c.name,
-				current.Config.Cmd,
-				c.containerConfig.Cmd,
-			)
-		}
-
-		// trigger removing the existing container and starting a new one
-		return false, nil
+	if !hasSuffixStrSlice(currentCmd, c.containerConfig.Cmd) {
+		return &FieldDiff{Field: FieldCmd, Current: currentCmd, Desired: c.containerConfig.Cmd}
 	}
-	return true, nil
+	return nil
 }
 
-func (c *Container) checkExistingEnv(current *dockerclient.ContainerInfo) (bool, error) {
+func (c *Container) checkExistingEnv(current *dockerclient.ContainerInfo) *FieldDiff {
+	var currentEnv []string
+	if current.Config != nil {
+		currentEnv = current.Config.Env
+	}
 	// we only check for a subset because the current env includes the
 	// environment variables defined the container dockerfile as well.
-	if !strSliceSubset(current.Config.Env, c.containerConfig.Env) {
-		// if we aren't allowed to remove the existing container, consider this a failure
-		if !c.removeExisting {
-			return false, stackerr.Newf(
-				"container %q running with env %v but desired env is %v",
-				c.name,
-				current.Config.Env,
-				c.containerConfig.Env,
-			)
-		}
-
-		// trigger removing the existing container and starting a new one
-		return false, nil
+	if !strSliceSubset(currentEnv, c.containerConfig.Env) {
+		return &FieldDiff{Field: FieldEnv, Current: currentEnv, Desired: c.containerConfig.Env}
 	}
-	return true, nil
+	return nil
 }
 
-func (c *Container) checkExistingBinds(current *dockerclient.ContainerInfo) (bool, error) {
+func (c *Container) checkExistingBinds(current *dockerclient.ContainerInfo) *FieldDiff {
 	// we only check for a subset because the current volumes includes the
 	// ones defined the container dockerfile as well.
-	if c.hostConfig != nil && !strSliceSubset(flattenVolumes(current.Volumes), c.hostConfig.Binds) {
-		// if we aren't allowed to remove the existing container, consider this a failure
-		if !c.removeExisting {
-			return false, stackerr.Newf(
-				"container %q running with volumes %v but desired volumes are %v",
-				c.name,
-				current.Volumes,
-				c.hostConfig.Binds,
-			)
+	currentBinds := flattenVolumes(current.Volumes)
+	if c.hostConfig != nil && !strSliceSubset(currentBinds, c.hostConfig.Binds) {
+		return &FieldDiff{Field: FieldBinds, Current: currentBinds, Desired: c.hostConfig.Binds}
+	}
+	return nil
+}
+
+func (c *Container) checkExistingPorts(current *dockerclient.ContainerInfo) *FieldDiff {
+	var currentPorts, desiredPorts map[string][]dockerclient.PortBinding
+	if c.hostConfig != nil {
+		desiredPorts = c.hostConfig.PortBindings
+	}
+	if current.HostConfig != nil {
+		currentPorts = current.HostConfig.PortBindings
+	}
+	if !matchPortBindings(currentPorts, desiredPorts) {
+		return &FieldDiff{
+			Field:   FieldPorts,
+			Current: flattenPortBindings(currentPorts),
+			Desired: flattenPortBindings(desiredPorts),
 		}
+	}
+	return nil
+}
 
-		// trigger removing the existing container and starting a new one
-		return false, nil
+func (c *Container) checkExistingExposedPorts(current *dockerclient.ContainerInfo) *FieldDiff {
+	var currentPorts []string
+	if current.Config != nil {
+		currentPorts = flattenExposedPorts(current.Config.ExposedPorts)
 	}
-	return true, nil
+	desiredPorts := flattenExposedPorts(c.containerConfig.ExposedPorts)
+	// we only check for a subset because the current ports include the ones
+	// exposed by the container dockerfile as well.
+	if !strSliceSubset(currentPorts, desiredPorts) {
+		return &FieldDiff{Field: FieldExposedPorts, Current: currentPorts, Desired: desiredPorts}
+	}
+	return nil
 }
 
 // ApplyGraph creates all the specified containers. It handles links making
-// sure the dependencies are created in the right order.
+// sure the dependencies are created in the right order. Links which form a
+// cycle are reported as an error before the containers in the cycle are
+// created.
 func ApplyGraph(docker dockerclient.Client, containers []*Container) error {
+	return walkGraph(containers, func(c *Container) error {
+		return c.Apply(docker)
+	})
+}
+
+// walkGraph calls f for each container, making sure the dependencies of a
+// container are visited before it.
+func walkGraph(containers []*Container, f func(c *Container) error) error {
 	known := map[string]struct{}{}
 	started := map[string]bool{}
 
@@ -351,11 +704,17 @@ func ApplyGraph(docker dockerclient.Client, containers []*Container) error {
 			}
 
 			starting = append(starting, c.name)
-			if err := c.Apply(docker); err != nil {
+			if err := f(c); err != nil {
 				return err
 			}
 		}
 
+		// nothing could be started, the remaining links form a cycle which
+		// would otherwise keep us going round forever
+		if len(starting) == 0 {
+			return stackerr.Newf("containers have cyclic links: %s", containerNames(nextRound))
+		}
+
 		// we successfully started them
 		for _, n := range starting {
 			started[n] = true
@@ -368,6 +727,30 @@ func ApplyGraph(docker dockerclient.Client, containers []*Container) error {
 	return nil
 }
 
+// Images returns the images referenced by the containers, without
+// duplicates. It is useful to collect the images for a dockerutil.SaveBundle.
+// Images built by the containers are not included.
+func Images(containers []*Container) []string {
+	var images []string
+	seen := map[string]bool{}
+	for _, c := range containers {
+		if c.containerConfig == nil || c.build != nil || seen[c.containerConfig.Image] {
+			continue
+		}
+		seen[c.containerConfig.Image] = true
+		images = append(images, c.containerConfig.Image)
+	}
+	return images
+}
+
+func containerNames(containers []*Container) string {
+	names := make([]string, len(containers))
+	for i, c := range containers {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}
+
 func equalStrSlice(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -408,9 +791,113 @@ func containsStr(all []string, target string) bool {
 }
 
 func flattenVolumes(volumes map[string]string) []string {
-	res := make([]string, len(volumes))
+	res := make([]string, 0, len(volumes))
 	for k, v := range volumes {
 		res = append(res, v+":"+k)
 	}
+	sort.Strings(res)
+	return res
+}
+
+// normalizePort adds the default protocol to a port such as "80".
+func normalizePort(port string) string {
+	if !strings.Contains(port, "/") {
+		return port + "/tcp"
+	}
+	return port
+}
+
+// normalizePortBinding makes the ways to bind to all interfaces or to an
+// ephemeral port equal.
+func normalizePortBinding(b dockerclient.PortBinding) dockerclient.PortBinding {
+	if b.HostIp == "0.0.0.0" {
+		b.HostIp = ""
+	}
+	if b.HostPort == "0" {
+		b.HostPort = ""
+	}
+	return b
+}
+
+// matchPortBindings reports if the current port bindings are the desired ones.
+// A desired binding without a host port matches the ephemeral port Docker
+// assigned.
+func matchPortBindings(current, desired map[string][]dockerclient.PortBinding) bool {
+	currentByPort := map[string][]dockerclient.PortBinding{}
+	for port, bindings := range current {
+		currentByPort[normalizePort(port)] = append(currentByPort[normalizePort(port)], bindings...)
+	}
+	desiredByPort := map[string][]dockerclient.PortBinding{}
+	for port, bindings := range desired {
+		desiredByPort[normalizePort(port)] = append(desiredByPort[normalizePort(port)], bindings...)
+	}
+	if len(currentByPort) != len(desiredByPort) {
+		return false
+	}
+
+	for port, desiredBindings := range desiredByPort {
+		currentBindings := currentByPort[port]
+		if len(currentBindings) != len(desiredBindings) {
+			return false
+		}
+		// bindings with a host port are matched first, so the ones without
+		// don't take the host port they need
+		var ordered []dockerclient.PortBinding
+		for _, d := range desiredBindings {
+			if d = normalizePortBinding(d); d.HostPort != "" {
+				ordered = append(ordered, d)
+			}
+		}
+		for _, d := range desiredBindings {
+			if d = normalizePortBinding(d); d.HostPort == "" {
+				ordered = append(ordered, d)
+			}
+		}
+
+		used := make([]bool, len(currentBindings))
+	desiredLoop:
+		for _, d := range ordered {
+			for i, c := range currentBindings {
+				c = normalizePortBinding(c)
+				if used[i] || c.HostIp != d.HostIp || (d.HostPort != "" && c.HostPort != d.HostPort) {
+					continue
+				}
+				used[i] = true
+				continue desiredLoop
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// flattenPortBindings formats the port bindings like the -p flag of docker
+// run, such as "127.0.0.1:8080:80/tcp".
+func flattenPortBindings(ports map[string][]dockerclient.PortBinding) []string {
+	var res []string
+	for port, bindings := range ports {
+		port = normalizePort(port)
+		for _, b := range bindings {
+			b = normalizePortBinding(b)
+			switch {
+			case b.HostIp != "":
+				res = append(res, b.HostIp+":"+b.HostPort+":"+port)
+			case b.HostPort != "":
+				res = append(res, b.HostPort+":"+port)
+			default:
+				res = append(res, port)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func flattenExposedPorts(ports map[string]struct{}) []string {
+	var res []string
+	for port := range ports {
+		res = append(res, normalizePort(port))
+	}
+	sort.Strings(res)
 	return res
 }
@@ -1,10 +1,21 @@
 package dockergoal
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/facebookgo/dockerutil"
 	"github.com/facebookgo/ensure"
 	"github.com/facebookgo/stackerr"
 	"github.com/samalba/dockerclient"
@@ -80,6 +91,55 @@ func TestContainerAuthConfig(t *testing.T) {
 	ensure.True(t, c.authConfig == config)
 }
 
+func TestContainerPullPolicy(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerPullPolicy(dockerutil.PullNever),
+	)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, c.pullPolicy, dockerutil.PullNever)
+}
+
+func TestContainerPullProgress(t *testing.T) {
+	var calls int
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerPullProgress(func(dockerutil.PullProgress) { calls++ }),
+	)
+	ensure.Nil(t, err)
+	c.pullProgress(dockerutil.PullProgress{})
+	ensure.DeepEqual(t, calls, 1)
+}
+
+func TestContainerImageIndex(t *testing.T) {
+	x := dockerutil.NewImageIndex(nil, 0)
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerImageIndex(x),
+	)
+	ensure.Nil(t, err)
+	ensure.True(t, c.imageIndex == x)
+}
+
+func TestContainerBundleDir(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerBundleDir("/bundles"),
+	)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, c.bundleDir, "/bundles")
+}
+
+func TestContainerRewriter(t *testing.T) {
+	r := &dockerutil.Rewriter{}
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerRewriter(r),
+	)
+	ensure.Nil(t, err)
+	ensure.True(t, c.rewriter == r)
+}
+
 func TestContainerAfterCreate(t *testing.T) {
 	givenErr := errors.New("")
 	f := func(string) error { return givenErr }
@@ -91,6 +151,112 @@ func TestContainerAfterCreate(t *testing.T) {
 	ensure.True(t, c.afterCreate("") == givenErr)
 }
 
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestContainerRequireDigest(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo@" + testDigest}),
+		ContainerRequireDigest(),
+	)
+	ensure.Nil(t, err)
+	ensure.True(t, c.requireDigest)
+}
+
+func TestContainerRequireDigestNotPinned(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo:latest"}),
+		ContainerRequireDigest(),
+	)
+	ensure.Err(t, err, regexp.MustCompile("is not pinned"))
+	ensure.True(t, c == nil)
+}
+
+func TestContainerRequireDigestWithoutConfig(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerRequireDigest(),
+	)
+	ensure.Err(t, err, regexp.MustCompile("has no image"))
+	ensure.True(t, c == nil)
+}
+
+func TestContainerBuild(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("web"),
+		ContainerBuild("src", "Dockerfile.dev"),
+	)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, *c.build, dockerutil.BuildOptions{
+		Context:    "src",
+		Dockerfile: "Dockerfile.dev",
+		Repository: "web",
+	})
+	ensure.NotNil(t, c.containerConfig)
+}
+
+func TestContainerBuildLowercasesName(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("MyApp"),
+		ContainerBuild("src", ""),
+	)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, c.build.Repository, "myapp")
+}
+
+func TestContainerBuildInvalidName(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("my-.app"),
+		ContainerBuild("src", ""),
+	)
+	ensure.Err(t, err, regexp.MustCompile("specify one using the Image of ContainerConfig"))
+	ensure.True(t, c == nil)
+}
+
+func TestContainerBuildWithImageName(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("web"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "example.com/team/web:dev"}),
+		ContainerBuild("src", ""),
+	)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, c.build.Repository, "example.com/team/web")
+}
+
+func TestContainerBuildRequireDigest(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("web"),
+		ContainerBuild("src", ""),
+		ContainerRequireDigest(),
+	)
+	ensure.Err(t, err, regexp.MustCompile("cannot require a digest"))
+	ensure.True(t, c == nil)
+}
+
+func TestApplyBuildRequiresDockerClient(t *testing.T) {
+	c, err := NewContainer(
+		ContainerName("web"),
+		ContainerBuild("src", ""),
+	)
+	ensure.Nil(t, err)
+	err = c.Apply(&mockClient{})
+	ensure.Err(t, err, regexp.MustCompile(`requires a \*dockerclient.DockerClient`))
+}
+
+func TestContainerBuiltConfig(t *testing.T) {
+	config := &dockerclient.ContainerConfig{Image: "web", Env: []string{"A=1"}}
+	c := &Container{containerConfig: config}
+	ensure.True(t, c.config() == config)
+
+	c.builtImage = "web:0123456789ab"
+	ensure.DeepEqual(t, c.config(), &dockerclient.ContainerConfig{
+		Image: "web:0123456789ab",
+		Env:   []string{"A=1"},
+	})
+	ensure.DeepEqual(t, config.Image, "web")
+}
+
 func TestApplyMakesNew(t *testing.T) {
 	const givenName = "x"
 	const givenID = "y"
@@ -198,6 +364,283 @@ func TestApplyAfterCreateError(t *testing.T) {
 	ensure.DeepEqual(t, removeCalls, 1)
 }
 
+func TestApplyPullNeverWithoutImage(t *testing.T) {
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerPullPolicy(dockerutil.PullNever),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return nil, dockerclient.ErrNotFound
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			return "", dockerclient.ErrNotFound
+		},
+	}
+	err = container.Apply(client)
+	notPresent, ok := err.(*dockerutil.ImageNotPresentError)
+	ensure.True(t, ok)
+	ensure.DeepEqual(t, notPresent.Image, "foo:latest")
+}
+
+func TestApplyPullAlwaysWithExisting(t *testing.T) {
+	const oldImageID = "old"
+	const newImageID = "new"
+	const givenID = "y"
+	var inspectCalls, pullCalls, createCalls, removeCalls int
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerPullPolicy(dockerutil.PullAlways),
+		ContainerRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			inspectCalls++
+			return &dockerclient.ContainerInfo{
+				Id:     givenID,
+				Image:  oldImageID,
+				Config: &dockerclient.ContainerConfig{},
+			}, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			pullCalls++
+			ensure.DeepEqual(t, name, "foo:latest")
+			return nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					RepoTags: []string{"foo:latest"},
+					Id:       newImageID,
+				},
+			}, nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			removeCalls++
+			return nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			createCalls++
+			return givenID, nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			return nil
+		},
+	}
+	ensure.Nil(t, container.Apply(client))
+	ensure.DeepEqual(t, pullCalls, 1)
+	ensure.DeepEqual(t, removeCalls, 1)
+	ensure.DeepEqual(t, createCalls, 1)
+	ensure.DeepEqual(t, inspectCalls, 2)
+}
+
+func TestApplyRequireDigestMismatch(t *testing.T) {
+	const givenID = "y"
+	const imageID = "i"
+	var inspectCalls, removeCalls int
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo@" + testDigest}),
+		ContainerRequireDigest(),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			inspectCalls++
+			switch inspectCalls {
+			case 1:
+				return nil, dockerclient.ErrNotFound
+			case 2:
+				return &dockerclient.ContainerInfo{Id: givenID, Image: imageID}, nil
+			}
+			panic("not reached")
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			return givenID, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					Id:          imageID,
+					RepoDigests: []string{"foo@sha256:" + strings.Repeat("f", 64)},
+				},
+			}, nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			removeCalls++
+			ensure.DeepEqual(t, id, givenID)
+			return nil
+		},
+	}
+	err = container.Apply(client)
+	ensure.Err(t, err, regexp.MustCompile("was requested"))
+	ensure.DeepEqual(t, removeCalls, 1)
+}
+
+func TestApplyRequireDigestMatch(t *testing.T) {
+	const givenID = "y"
+	const imageID = "i"
+	var inspectCalls, startCalls int
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo@" + testDigest}),
+		ContainerRequireDigest(),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			inspectCalls++
+			switch inspectCalls {
+			case 1:
+				return nil, dockerclient.ErrNotFound
+			case 2:
+				return &dockerclient.ContainerInfo{Id: givenID, Image: imageID}, nil
+			}
+			panic("not reached")
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			return givenID, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					Id:          imageID,
+					RepoDigests: []string{"docker.io/library/foo@" + testDigest},
+				},
+			}, nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			startCalls++
+			return nil
+		},
+	}
+	ensure.Nil(t, container.Apply(client))
+	ensure.DeepEqual(t, startCalls, 1)
+}
+
+func TestApplyRequireDigestRewritten(t *testing.T) {
+	const givenID = "y"
+	const imageID = "i"
+	var pulls []string
+	var created *dockerclient.ContainerConfig
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo@" + testDigest}),
+		ContainerRequireDigest(),
+		ContainerRewriter(&dockerutil.Rewriter{
+			Rules: []dockerutil.RewriteRule{{
+				Prefix:       "docker.io/",
+				Replacements: []string{"mirror.example.com/"},
+			}},
+		}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			if created == nil {
+				return nil, dockerclient.ErrNotFound
+			}
+			return &dockerclient.ContainerInfo{Id: givenID, Image: imageID}, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			if len(pulls) == 0 {
+				return nil, nil
+			}
+			return []*dockerclient.Image{
+				{
+					Id:          imageID,
+					RepoDigests: []string{"mirror.example.com/library/foo@" + testDigest},
+				},
+			}, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			pulls = append(pulls, name)
+			return nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			if len(pulls) == 0 {
+				return "", dockerclient.ErrNotFound
+			}
+			created = config
+			return givenID, nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			return nil
+		},
+	}
+	ensure.Nil(t, container.Apply(client))
+	ensure.DeepEqual(t, pulls, []string{"mirror.example.com/library/foo@" + testDigest})
+	ensure.DeepEqual(t, created.Image, "mirror.example.com/library/foo@"+testDigest)
+}
+
+func TestApplyRequireDigestFromBundle(t *testing.T) {
+	const givenID = "y"
+	const imageID = "i"
+	dir, err := ioutil.TempDir("", "dockergoal-bundle-test")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	manifest, err := json.Marshal(&dockerutil.BundleManifest{
+		Images: []dockerutil.BundleImage{{
+			Reference: "docker.io/library/foo@" + testDigest,
+			ID:        imageID,
+			Digest:    testDigest,
+		}},
+	})
+	ensure.Nil(t, err)
+	var bundle bytes.Buffer
+	tw := tar.NewWriter(&bundle)
+	ensure.Nil(t, tw.WriteHeader(&tar.Header{Name: "manifest.json", Size: int64(len(manifest))}))
+	_, err = tw.Write(manifest)
+	ensure.Nil(t, err)
+	ensure.Nil(t, tw.WriteHeader(&tar.Header{Name: "images.tar"}))
+	ensure.Nil(t, tw.Close())
+	ensure.Nil(t, ioutil.WriteFile(filepath.Join(dir, "foo.tar"), bundle.Bytes(), 0644))
+
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo@" + testDigest}),
+		ContainerRequireDigest(),
+		ContainerBundleDir(dir),
+	)
+	ensure.Nil(t, err)
+
+	// the daemon doesn't keep the digest of loaded images
+	var loaded []*dockerclient.Image
+	var created *dockerclient.ContainerConfig
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			if created == nil {
+				return nil, dockerclient.ErrNotFound
+			}
+			return &dockerclient.ContainerInfo{Id: givenID, Image: imageID}, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return loaded, nil
+		},
+		loadImage: func(r io.Reader) error {
+			loaded = []*dockerclient.Image{{Id: imageID}}
+			return nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			if loaded == nil {
+				return "", dockerclient.ErrNotFound
+			}
+			created = config
+			return givenID, nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			return nil
+		},
+	}
+	ensure.Nil(t, container.Apply(client))
+	ensure.DeepEqual(t, created.Image, imageID)
+}
+
 func TestApplyInspectAfterCreateError(t *testing.T) {
 	container, err := NewContainer(
 		ContainerName("x"),
@@ -249,6 +692,57 @@ func TestApplyStartError(t *testing.T) {
 	ensure.True(t, stackerr.HasUnderlying(err, stackerr.Equals(givenErr)))
 }
 
+func TestContainerWaitForLog(t *testing.T) {
+	re := regexp.MustCompile("ready")
+	c, err := NewContainer(
+		ContainerName("x"),
+		ContainerWaitForLog(re, 2, time.Minute),
+	)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, c.readyLog, &readyLog{re: re, n: 2, timeout: time.Minute})
+}
+
+func TestApplyWaitsForLog(t *testing.T) {
+	const image = "x"
+	const id = "y"
+	var startCalls int
+	container := &Container{
+		containerConfig: &dockerclient.ContainerConfig{
+			Image: image,
+		},
+		readyLog: &readyLog{re: regexp.MustCompile("ready"), n: 1},
+	}
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			// the container exits right after starting
+			return &dockerclient.ContainerInfo{
+				Id:     "a",
+				Image:  id,
+				Config: &dockerclient.ContainerConfig{Tty: true},
+			}, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					RepoTags: []string{image},
+					Id:       id,
+				},
+			}, nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			startCalls++
+			return nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			ensure.DeepEqual(t, id, "a")
+			return ioutil.NopCloser(strings.NewReader("starting\n")), nil
+		},
+	}
+	err := container.Apply(client)
+	ensure.DeepEqual(t, startCalls, 1)
+	ensure.Err(t, err, regexp.MustCompile(`container "a" exited`))
+}
+
 func TestApplyForceRemoveExisting(t *testing.T) {
 	const removeID = "y"
 	const newID = "z"
@@ -515,6 +1009,32 @@ func TestCheckExistingWithDesiredImage(t *testing.T) {
 	ensure.True(t, ok)
 }
 
+func TestCheckExistingWithDesiredImageDifferentSpelling(t *testing.T) {
+	const id = "y"
+	container := &Container{
+		containerConfig: &dockerclient.ContainerConfig{
+			Image: "docker.io/library/redis",
+		},
+	}
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					RepoTags: []string{"redis:latest"},
+					Id:       id,
+				},
+			}, nil
+		},
+	}
+	ci := &dockerclient.ContainerInfo{
+		Image:  id,
+		Config: &dockerclient.ContainerConfig{},
+	}
+	ok, err := container.checkExisting(client, ci)
+	ensure.Nil(t, err)
+	ensure.True(t, ok)
+}
+
 func TestCheckExistingWithoutDesiredImageAndNoRemoveExisting(t *testing.T) {
 	const image = "x"
 	container := &Container{
@@ -832,6 +1352,153 @@ func TestCheckExistingWithoutDesiredBindsWithRemoveExisting(t *testing.T) {
 	ensure.False(t, ok)
 }
 
+func TestCheckExistingWithoutDesiredPortsWithoutRemoveExisting(t *testing.T) {
+	container := &Container{
+		containerConfig: &dockerclient.ContainerConfig{
+			Image:        "in1",
+			ExposedPorts: map[string]struct{}{"80": {}},
+		},
+		hostConfig: &dockerclient.HostConfig{
+			PortBindings: map[string][]dockerclient.PortBinding{
+				"80/tcp": {{HostPort: "9090"}},
+			},
+		},
+	}
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					RepoTags: []string{"in1"},
+					Id:       "ii1",
+				},
+			}, nil
+		},
+	}
+	ci := &dockerclient.ContainerInfo{
+		Image: "ii1",
+		Id:    "y",
+		Config: &dockerclient.ContainerConfig{
+			ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+		},
+		HostConfig: &dockerclient.HostConfig{
+			PortBindings: map[string][]dockerclient.PortBinding{
+				"80/tcp": {{HostPort: "8080"}},
+			},
+		},
+	}
+	ok, err := container.checkExisting(client, ci)
+	ensure.Err(t, err, regexp.MustCompile(`running with ports \[8080:80/tcp\] but desired ports are \[9090:80/tcp\]`))
+	ensure.False(t, ok)
+}
+
+func TestCheckExistingWithoutDesiredPortsWithRemoveExisting(t *testing.T) {
+	container := &Container{
+		removeExisting: true,
+		containerConfig: &dockerclient.ContainerConfig{
+			Image:        "in1",
+			ExposedPorts: map[string]struct{}{"80": {}},
+		},
+		hostConfig: &dockerclient.HostConfig{
+			PortBindings: map[string][]dockerclient.PortBinding{
+				"80/tcp": {{HostPort: "9090"}},
+			},
+		},
+	}
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					RepoTags: []string{"in1"},
+					Id:       "ii1",
+				},
+			}, nil
+		},
+	}
+	ci := &dockerclient.ContainerInfo{
+		Image: "ii1",
+		Id:    "y",
+		Config: &dockerclient.ContainerConfig{
+			ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+		},
+		HostConfig: &dockerclient.HostConfig{
+			PortBindings: map[string][]dockerclient.PortBinding{
+				"80/tcp": {{HostPort: "8080"}},
+			},
+		},
+	}
+	ok, err := container.checkExisting(client, ci)
+	ensure.Nil(t, err)
+	ensure.False(t, ok)
+}
+
+func TestCheckExistingWithDesiredEphemeralPort(t *testing.T) {
+	container := &Container{
+		containerConfig: &dockerclient.ContainerConfig{
+			Image:        "in1",
+			ExposedPorts: map[string]struct{}{"80": {}},
+		},
+		hostConfig: &dockerclient.HostConfig{
+			PortBindings: map[string][]dockerclient.PortBinding{
+				"80": {{}},
+			},
+		},
+	}
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					RepoTags: []string{"in1"},
+					Id:       "ii1",
+				},
+			}, nil
+		},
+	}
+	ci := &dockerclient.ContainerInfo{
+		Image: "ii1",
+		Id:    "y",
+		Config: &dockerclient.ContainerConfig{
+			ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+		},
+		HostConfig: &dockerclient.HostConfig{
+			PortBindings: map[string][]dockerclient.PortBinding{
+				"80/tcp": {{HostIp: "0.0.0.0", HostPort: "32768"}},
+			},
+		},
+	}
+	ok, err := container.checkExisting(client, ci)
+	ensure.Nil(t, err)
+	ensure.True(t, ok)
+}
+
+func TestCheckExistingWithoutDesiredExposedPorts(t *testing.T) {
+	container := &Container{
+		containerConfig: &dockerclient.ContainerConfig{
+			Image:        "in1",
+			ExposedPorts: map[string]struct{}{"80": {}, "53/udp": {}},
+		},
+	}
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					RepoTags: []string{"in1"},
+					Id:       "ii1",
+				},
+			}, nil
+		},
+	}
+	ci := &dockerclient.ContainerInfo{
+		Image: "ii1",
+		Id:    "y",
+		Config: &dockerclient.ContainerConfig{
+			ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+		},
+	}
+	ok, err := container.checkExisting(client, ci)
+	ensure.Err(t, err, regexp.MustCompile("but desired exposed ports are"))
+	ensure.False(t, ok)
+}
+
 func TestApplyWithExistingRemoveError(t *testing.T) {
 	const image = "x"
 	givenErr := errors.New("")
@@ -968,6 +1635,21 @@ func TestApplyGraphWithUnknownLinks(t *testing.T) {
 	ensure.Err(t, err, regexp.MustCompile(`expects unknown link "baz:foo"`))
 }
 
+func TestApplyGraphWithCyclicLinks(t *testing.T) {
+	a, err := NewContainer(
+		ContainerName("a"),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"b:b"}}),
+	)
+	ensure.Nil(t, err)
+	b, err := NewContainer(
+		ContainerName("b"),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"a:a"}}),
+	)
+	ensure.Nil(t, err)
+	err = ApplyGraph(&mockClient{}, []*Container{a, b})
+	ensure.Err(t, err, regexp.MustCompile("cyclic links: a, b"))
+}
+
 func TestApplyGraphApplyError(t *testing.T) {
 	givenErr := errors.New("")
 	containers := []*Container{
@@ -985,6 +1667,17 @@ func TestApplyGraphApplyError(t *testing.T) {
 	ensure.True(t, stackerr.HasUnderlying(err, stackerr.Equals(givenErr)))
 }
 
+func TestImages(t *testing.T) {
+	containers := []*Container{
+		{containerConfig: &dockerclient.ContainerConfig{Image: "a"}},
+		{},
+		{containerConfig: &dockerclient.ContainerConfig{Image: "b"}},
+		{containerConfig: &dockerclient.ContainerConfig{Image: "a"}},
+		{containerConfig: &dockerclient.ContainerConfig{Image: "c"}, build: &dockerutil.BuildOptions{}},
+	}
+	ensure.DeepEqual(t, Images(containers), []string{"a", "b"})
+}
+
 func TestEqualStrSlice(t *testing.T) {
 	cases := []struct {
 		A, B  []string
@@ -1091,3 +1784,114 @@ func TestStrSliceSubset(t *testing.T) {
 		ensure.DeepEqual(t, strSliceSubset(c.All, c.Subset), c.Result, c)
 	}
 }
+
+func TestMatchPortBindings(t *testing.T) {
+	type ports = map[string][]dockerclient.PortBinding
+	cases := []struct {
+		Current ports
+		Desired ports
+		Result  bool
+	}{
+		{
+			Current: nil,
+			Desired: ports{},
+			Result:  true,
+		},
+		{
+			Current: ports{"80/tcp": {{HostPort: "8080"}}},
+			Desired: ports{"80": {{HostPort: "8080"}}},
+			Result:  true,
+		},
+		{
+			Current: ports{"80/tcp": {{HostPort: "8080"}}},
+			Desired: ports{"80/udp": {{HostPort: "8080"}}},
+			Result:  false,
+		},
+		{
+			Current: ports{"80/tcp": {{HostPort: "8080"}}},
+			Desired: nil,
+			Result:  false,
+		},
+		{
+			Current: ports{"80/tcp": {{HostIp: "0.0.0.0", HostPort: "8080"}}},
+			Desired: ports{"80/tcp": {{HostPort: "8080"}}},
+			Result:  true,
+		},
+		{
+			Current: ports{"80/tcp": {{HostIp: "0.0.0.0", HostPort: "8080"}}},
+			Desired: ports{"80/tcp": {{HostIp: "127.0.0.1", HostPort: "8080"}}},
+			Result:  false,
+		},
+		{
+			Current: ports{"80/tcp": {{HostPort: "32768"}}},
+			Desired: ports{"80/tcp": {{HostPort: "0"}}},
+			Result:  true,
+		},
+		{
+			Current: ports{"80/tcp": {{HostPort: "32768"}, {HostPort: "8080"}}},
+			Desired: ports{"80/tcp": {{HostPort: "8080"}, {}}},
+			Result:  true,
+		},
+		{
+			Current: ports{"80/tcp": {{HostPort: "8080"}, {HostPort: "32768"}}},
+			Desired: ports{"80/tcp": {{HostPort: ""}, {HostPort: "8080"}}},
+			Result:  true,
+		},
+		{
+			Current: ports{"80/tcp": {{HostPort: "8080"}}},
+			Desired: ports{"80/tcp": {{HostPort: "8080"}, {}}},
+			Result:  false,
+		},
+	}
+
+	for _, c := range cases {
+		ensure.DeepEqual(t, matchPortBindings(c.Current, c.Desired), c.Result, c)
+	}
+}
+
+func TestFlattenPortBindings(t *testing.T) {
+	actual := flattenPortBindings(map[string][]dockerclient.PortBinding{
+		"80":      {{HostIp: "127.0.0.1", HostPort: "8080"}, {HostIp: "0.0.0.0", HostPort: "0"}},
+		"53/udp":  {{HostPort: "53"}},
+		"443/tcp": {{HostIp: "127.0.0.1"}},
+	})
+	ensure.DeepEqual(t, actual, []string{
+		"127.0.0.1:8080:80/tcp",
+		"127.0.0.1::443/tcp",
+		"53:53/udp",
+		"80/tcp",
+	})
+}
+
+func TestContainerSeedRequiresDockerClient(t *testing.T) {
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerSeedFile("/etc/app.conf", []byte("port 80"), 0644),
+		ContainerSeedFS("/etc/app", fstest.MapFS{}),
+	)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(container.seeds), 2)
+
+	var inspectCalls int
+	var removed []string
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			inspectCalls++
+			if inspectCalls == 1 {
+				return nil, dockerclient.ErrNotFound
+			}
+			return &dockerclient.ContainerInfo{Id: "y"}, nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			return "y", nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			removed = append(removed, id)
+			return nil
+		},
+	}
+	err = container.Apply(client)
+	ensure.Err(t, err, regexp.MustCompile(`requires a \*dockerclient.DockerClient`))
+	ensure.DeepEqual(t, removed, []string{"y"})
+}
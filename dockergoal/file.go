@@ -0,0 +1,81 @@
+package dockergoal
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// ContainerSpec is the serialized form of a Container as found in a goal
+// file. The Config and HostConfig use the same JSON representation as the
+// Docker API.
+type ContainerSpec struct {
+	Name           string
+	Config         *dockerclient.ContainerConfig
+	HostConfig     *dockerclient.HostConfig
+	RemoveExisting bool
+	PullPolicy     string
+}
+
+// ReadGoal reads a goal file, which is a JSON array of ContainerSpecs, and
+// returns the corresponding containers. The given options are applied to every
+// container after the ones from the file, which is useful for credentials.
+func ReadGoal(r io.Reader, options ...ContainerOption) ([]*Container, error) {
+	var specs []ContainerSpec
+	if err := json.NewDecoder(r).Decode(&specs); err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	containers := make([]*Container, 0, len(specs))
+	for _, s := range specs {
+		specOptions := []ContainerOption{
+			ContainerName(s.Name),
+			ContainerConfig(s.Config),
+			ContainerHostConfig(s.HostConfig),
+		}
+		if s.RemoveExisting {
+			specOptions = append(specOptions, ContainerRemoveExisting())
+		}
+		if s.PullPolicy != "" {
+			p, err := parsePullPolicy(s.PullPolicy)
+			if err != nil {
+				return nil, err
+			}
+			specOptions = append(specOptions, ContainerPullPolicy(p))
+		}
+
+		c, err := NewContainer(append(specOptions, options...)...)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// ReadGoalFile is ReadGoal for the named file.
+func ReadGoalFile(file string, options ...ContainerOption) ([]*Container, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	defer f.Close()
+	return ReadGoal(f, options...)
+}
+
+func parsePullPolicy(s string) (dockerutil.PullPolicy, error) {
+	for _, p := range []dockerutil.PullPolicy{
+		dockerutil.PullIfNotPresent,
+		dockerutil.PullAlways,
+		dockerutil.PullNever,
+	} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, stackerr.Newf("unknown pull policy %q", s)
+}
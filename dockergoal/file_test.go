@@ -0,0 +1,57 @@
+package dockergoal
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func TestReadGoal(t *testing.T) {
+	const goal = `[
+		{
+			"Name": "db",
+			"Config": {"Image": "postgres:9"},
+			"RemoveExisting": true,
+			"PullPolicy": "Always"
+		},
+		{
+			"Name": "web",
+			"Config": {"Image": "app", "Env": ["A=1"]},
+			"HostConfig": {"Links": ["db:db"]}
+		}
+	]`
+	ac := &dockerclient.AuthConfig{}
+	containers, err := ReadGoal(strings.NewReader(goal), ContainerAuthConfig(ac))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(containers), 2)
+
+	ensure.DeepEqual(t, containers[0].name, "db")
+	ensure.DeepEqual(t, containers[0].containerConfig.Image, "postgres:9")
+	ensure.True(t, containers[0].removeExisting)
+	ensure.DeepEqual(t, containers[0].pullPolicy, dockerutil.PullAlways)
+	ensure.True(t, containers[0].authConfig == ac)
+
+	ensure.DeepEqual(t, containers[1].name, "web")
+	ensure.DeepEqual(t, containers[1].containerConfig.Env, []string{"A=1"})
+	ensure.DeepEqual(t, containers[1].hostConfig.Links, []string{"db:db"})
+	ensure.False(t, containers[1].removeExisting)
+}
+
+func TestReadGoalUnknownPullPolicy(t *testing.T) {
+	_, err := ReadGoal(strings.NewReader(`[{"Name": "x", "PullPolicy": "Sometimes"}]`))
+	ensure.Err(t, err, regexp.MustCompile("unknown pull policy"))
+}
+
+func TestReadGoalMissingName(t *testing.T) {
+	_, err := ReadGoal(strings.NewReader(`[{}]`))
+	ensure.DeepEqual(t, err, errNameMissing)
+}
+
+func TestReadGoalInvalidJSON(t *testing.T) {
+	_, err := ReadGoal(strings.NewReader(`{`))
+	ensure.NotNil(t, err)
+}
@@ -0,0 +1,325 @@
+package dockergoal
+
+import (
+	"fmt"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// An ActionType is a kind of change in a Plan.
+type ActionType string
+
+const (
+	// ActionPull pulls the image, or loads it from the bundle directory.
+	ActionPull ActionType = "pull"
+
+	// ActionBuild builds the image of a container using ContainerBuild.
+	ActionBuild ActionType = "build"
+
+	// ActionCreate creates a container which does not exist.
+	ActionCreate ActionType = "create"
+
+	// ActionRecreate removes the existing container and creates a new one.
+	ActionRecreate ActionType = "recreate"
+
+	// ActionStart starts the container.
+	ActionStart ActionType = "start"
+
+	// ActionUnchanged leaves a running container with the desired state alone.
+	ActionUnchanged ActionType = "unchanged"
+)
+
+// An Action is a single step of a Plan.
+type Action struct {
+	Type      ActionType
+	Container string
+
+	// Image is the desired image of the container.
+	Image string
+
+	// Reasons explains why the container is recreated.
+	Reasons []string
+
+	// Diff lists the differences which cause the container to be recreated.
+	// It is nil if the container is recreated regardless.
+	Diff *Diff
+}
+
+// A Plan is the list of actions Apply or ApplyGraph would take, made without
+// changing anything. It can be applied later, which fails with a DriftError
+// if the containers changed in the meantime.
+type Plan struct {
+	steps []*planStep
+}
+
+// planStep holds the actions for a single container along with the state they
+// were planned against.
+type planStep struct {
+	container *Container
+	actions   []Action
+	image     string
+	imageID   string
+	current   containerState
+}
+
+// containerState is the part of an existing container which a plan depends
+// on. The zero value is a container which does not exist.
+type containerState struct {
+	id      string
+	image   string
+	running bool
+}
+
+func newContainerState(ci *dockerclient.ContainerInfo) containerState {
+	return containerState{id: ci.Id, image: ci.Image, running: ci.State.Running}
+}
+
+// A DriftError is returned when applying a Plan if a container or its image
+// changed since the plan was made.
+type DriftError struct {
+	Container string
+	Reason    string
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("container %q changed since it was planned: %s", e.Container, e.Reason)
+}
+
+// Plan returns the actions Apply would take, without changing anything.
+func (c *Container) Plan(docker dockerclient.Client) (*Plan, error) {
+	s, err := c.plan(docker)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{steps: []*planStep{s}}, nil
+}
+
+// PlanGraph returns the actions ApplyGraph would take, without changing
+// anything. The actions are in the order they would be applied.
+func PlanGraph(docker dockerclient.Client, containers []*Container) (*Plan, error) {
+	var p Plan
+	err := walkGraph(containers, func(c *Container) error {
+		s, err := c.plan(docker)
+		if err != nil {
+			return err
+		}
+		p.steps = append(p.steps, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Actions returns the actions of the plan in order.
+func (p *Plan) Actions() []Action {
+	var actions []Action
+	for _, s := range p.steps {
+		actions = append(actions, s.actions...)
+	}
+	return actions
+}
+
+// Apply takes the planned actions. Before acting on a container it makes sure
+// the container is still in the state it was planned against, and after
+// pulling or building an image that the image is still the one planned for.
+// Otherwise a DriftError is returned and nothing more is changed.
+func (p *Plan) Apply(docker dockerclient.Client) error {
+	for _, s := range p.steps {
+		if err := s.apply(docker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Container) plan(docker dockerclient.Client) (*planStep, error) {
+	image, imageID, err := c.localImage(docker)
+	if err != nil {
+		return nil, err
+	}
+	if imageID == "" && c.build == nil && c.pullPolicy == dockerutil.PullNever && c.bundleDir == "" {
+		return nil, &dockerutil.ImageNotPresentError{Image: image}
+	}
+	s := &planStep{container: c, image: image, imageID: imageID}
+
+	switch {
+	case c.build != nil:
+		if imageID == "" {
+			s.add(ActionBuild, nil)
+		}
+	case imageID == "" || c.pullPolicy == dockerutil.PullAlways:
+		s.add(ActionPull, nil)
+	}
+
+	ci, err := docker.InspectContainer(c.name)
+	if err != nil {
+		// unknown error, bail
+		if err != dockerclient.ErrNotFound {
+			return nil, stackerr.Wrap(err)
+		}
+
+		// container does not exist, create it
+		s.add(ActionCreate, nil)
+		s.add(ActionStart, nil)
+		return s, nil
+	}
+	s.current = newContainerState(ci)
+
+	if c.forceRemoveExisting {
+		s.add(ActionRecreate, nil)
+		s.add(ActionStart, nil)
+		return s, nil
+	}
+
+	diff := c.diff(ci, s.image, imageID)
+	ok, err := c.acceptExisting(diff)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !ok:
+		s.add(ActionRecreate, diff)
+		s.add(ActionStart, nil)
+	case !ci.State.Running:
+		s.add(ActionStart, nil)
+	default:
+		s.add(ActionUnchanged, nil)
+	}
+	return s, nil
+}
+
+func (s *planStep) add(t ActionType, diff *Diff) {
+	a := Action{
+		Type:      t,
+		Container: s.container.name,
+		Image:     s.image,
+		Diff:      diff,
+	}
+	switch {
+	case diff != nil:
+		a.Reasons = diff.Reasons()
+	case t == ActionRecreate:
+		a.Reasons = []string{"ContainerForceRemoveExisting is set"}
+	}
+	s.actions = append(s.actions, a)
+}
+
+// localImage returns the desired image along with its ID, without pulling,
+// building or loading it. The ID is empty if the image is not present.
+func (c *Container) localImage(docker dockerclient.Client) (string, string, error) {
+	image := c.config().Image
+	if c.build != nil {
+		hash, err := dockerutil.ContextHash(c.build.Context, c.build.Dockerfile)
+		if err != nil {
+			return "", "", err
+		}
+		image = c.build.Repository + ":" + hash
+	}
+
+	options := append(
+		c.pullOptions(dockerutil.PullNever),
+		dockerutil.WithBundleDir(""),
+	)
+	imageID, err := dockerutil.ImageID(docker, image, c.authConfig, options...)
+	if err != nil {
+		if _, ok := err.(*dockerutil.ImageNotPresentError); !ok {
+			return "", "", err
+		}
+	}
+	return image, imageID, nil
+}
+
+func (s *planStep) apply(docker dockerclient.Client) error {
+	c := s.container
+	ci, err := s.checkContainer(docker)
+	if err != nil {
+		return err
+	}
+
+	if c.build != nil {
+		// the image was either there when planning or is built below
+		c.builtImage = s.image
+	}
+
+	created := false
+	for _, a := range s.actions {
+		switch a.Type {
+		case ActionPull:
+			id, err := dockerutil.ImageID(docker, s.image, c.authConfig, c.pullOptions(c.pullPolicy)...)
+			if err != nil {
+				return err
+			}
+			if s.imageID != "" && id != s.imageID {
+				return &DriftError{
+					Container: c.name,
+					Reason:    fmt.Sprintf("image %q now has id %q instead of %q", s.image, id, s.imageID),
+				}
+			}
+		case ActionBuild:
+			if err := c.buildImage(docker); err != nil {
+				return err
+			}
+			if c.builtImage != s.image {
+				return &DriftError{
+					Container: c.name,
+					Reason:    fmt.Sprintf("built image %q instead of %q", c.builtImage, s.image),
+				}
+			}
+		case ActionRecreate:
+			if err := docker.RemoveContainer(s.current.id, true, false); err != nil {
+				return stackerr.Wrap(err)
+			}
+			fallthrough
+		case ActionCreate:
+			// the image was pulled or built above
+			if ci, err = c.create(docker, dockerutil.PullNever); err != nil {
+				return err
+			}
+			created = true
+		case ActionStart:
+			if err := c.start(docker, ci, created); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkContainer returns the container if it's still in the planned state, or
+// a DriftError.
+func (s *planStep) checkContainer(docker dockerclient.Client) (*dockerclient.ContainerInfo, error) {
+	name := s.container.name
+	ci, err := docker.InspectContainer(name)
+	var current containerState
+	if err != nil {
+		if err != dockerclient.ErrNotFound {
+			return nil, stackerr.Wrap(err)
+		}
+		ci = nil
+	} else {
+		current = newContainerState(ci)
+	}
+
+	var reason string
+	switch {
+	case current == s.current:
+		return ci, nil
+	case s.current.id == "":
+		reason = "it was created"
+	case current.id == "":
+		reason = "it was removed"
+	case current.id != s.current.id:
+		reason = fmt.Sprintf("it was replaced by %q", current.id)
+	case current.image != s.current.image:
+		reason = fmt.Sprintf("its image changed from %q to %q", s.current.image, current.image)
+	case current.running:
+		reason = "it was started"
+	default:
+		reason = "it was stopped"
+	}
+	return nil, &DriftError{Container: name, Reason: reason}
+}
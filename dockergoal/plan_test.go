@@ -0,0 +1,277 @@
+package dockergoal
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+// planHost simulates the containers and images of a docker host, recording
+// the calls which change them.
+type planHost struct {
+	containers map[string]*dockerclient.ContainerInfo
+	images     map[string]string
+	pulled     map[string]string
+	calls      []string
+}
+
+func (h *planHost) client() *mockClient {
+	return &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			ci, ok := h.containers[name]
+			if !ok {
+				return nil, dockerclient.ErrNotFound
+			}
+			copied := *ci
+			return &copied, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			var images []*dockerclient.Image
+			for tag, id := range h.images {
+				images = append(images, &dockerclient.Image{Id: id, RepoTags: []string{tag}})
+			}
+			return images, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			h.calls = append(h.calls, "pull "+name)
+			h.images[name] = h.pulled[name]
+			return nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			h.calls = append(h.calls, "remove "+id)
+			for name, ci := range h.containers {
+				if ci.Id == id {
+					delete(h.containers, name)
+				}
+			}
+			return nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			h.calls = append(h.calls, "create "+name)
+			h.containers[name] = &dockerclient.ContainerInfo{
+				Id:     name + "-new",
+				Image:  h.images[config.Image],
+				Config: config,
+			}
+			return name + "-new", nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			h.calls = append(h.calls, "start "+id)
+			return nil
+		},
+	}
+}
+
+func TestPlanGraph(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"db": {
+				Id:     "db-old",
+				Image:  "old",
+				Config: &dockerclient.ContainerConfig{Env: []string{"A=1"}},
+			},
+			"cache": {
+				Id:     "cache-old",
+				Image:  "redis-id",
+				Config: &dockerclient.ContainerConfig{},
+			},
+		},
+		images: map[string]string{
+			"postgres:latest": "postgres-id",
+			"redis:latest":    "redis-id",
+		},
+		pulled: map[string]string{"web:latest": "web-id"},
+	}
+	h.containers["cache"].State.Running = true
+
+	db, err := NewContainer(
+		ContainerName("db"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "postgres", Env: []string{"A=2"}}),
+		ContainerRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "web"}),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+	)
+	ensure.Nil(t, err)
+	cache, err := NewContainer(
+		ContainerName("cache"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := PlanGraph(h.client(), []*Container{web, db, cache})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, h.calls, []string(nil))
+	ensure.DeepEqual(t, plan.Actions(), []Action{
+		{
+			Type:      ActionRecreate,
+			Container: "db",
+			Image:     "postgres",
+			Reasons: []string{
+				`running with image "old" but desired image is "postgres" with id "postgres-id"`,
+				`running with env [A=(redacted)] but desired env is [A=(redacted)]`,
+			},
+			Diff: &Diff{
+				Container: "db",
+				Image:     "postgres",
+				Fields: []FieldDiff{
+					{Field: FieldImage, Current: []string{"old"}, Desired: []string{"postgres-id"}},
+					{Field: FieldEnv, Current: []string{"A=1"}, Desired: []string{"A=2"}},
+				},
+			},
+		},
+		{Type: ActionStart, Container: "db", Image: "postgres"},
+		{Type: ActionUnchanged, Container: "cache", Image: "redis"},
+		{Type: ActionPull, Container: "web", Image: "web"},
+		{Type: ActionCreate, Container: "web", Image: "web"},
+		{Type: ActionStart, Container: "web", Image: "web"},
+	})
+
+	ensure.Nil(t, plan.Apply(h.client()))
+	ensure.DeepEqual(t, h.calls, []string{
+		"remove db-old",
+		"create db",
+		"start db-new",
+		"pull web:latest",
+		"create web",
+		"start web-new",
+	})
+}
+
+func TestPlanStartsStopped(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"x": {Id: "x-old", Image: "foo-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{"foo:latest": "foo-id"},
+	}
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := container.Plan(h.client())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, plan.Actions(), []Action{
+		{Type: ActionStart, Container: "x", Image: "foo"},
+	})
+	ensure.Nil(t, plan.Apply(h.client()))
+	ensure.DeepEqual(t, h.calls, []string{"start x-old"})
+}
+
+func TestPlanForceRemoveExisting(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"x": {Id: "x-old", Image: "foo-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{"foo:latest": "foo-id"},
+	}
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerForceRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := container.Plan(h.client())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, plan.Actions()[0].Type, ActionRecreate)
+	ensure.Nil(t, plan.Apply(h.client()))
+	ensure.DeepEqual(t, h.calls, []string{"remove x-old", "create x", "start x-new"})
+}
+
+func TestPlanMismatchWithoutRemoveExisting(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"x": {Id: "x-old", Image: "old", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{"foo:latest": "foo-id"},
+	}
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+	)
+	ensure.Nil(t, err)
+
+	_, err = container.Plan(h.client())
+	ensure.Err(t, err, regexp.MustCompile("but desired image is"))
+}
+
+func TestPlanPullNeverWithoutImage(t *testing.T) {
+	h := &planHost{images: map[string]string{}}
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerPullPolicy(dockerutil.PullNever),
+	)
+	ensure.Nil(t, err)
+
+	_, err = container.Plan(h.client())
+	_, ok := err.(*dockerutil.ImageNotPresentError)
+	ensure.True(t, ok)
+}
+
+func TestPlanDriftedContainer(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"x": {Id: "x-old", Image: "foo-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{"foo:latest": "foo-id"},
+	}
+	h.containers["x"].State.Running = true
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := container.Plan(h.client())
+	ensure.Nil(t, err)
+
+	h.containers["x"].State.Running = false
+	err = plan.Apply(h.client())
+	ensure.DeepEqual(t, err, &DriftError{Container: "x", Reason: "it was stopped"})
+	ensure.DeepEqual(t, h.calls, []string(nil))
+
+	delete(h.containers, "x")
+	err = plan.Apply(h.client())
+	ensure.DeepEqual(t, err, &DriftError{Container: "x", Reason: "it was removed"})
+}
+
+func TestPlanDriftedImage(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"x": {Id: "x-old", Image: "foo-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{"foo:latest": "foo-id"},
+		pulled: map[string]string{"foo:latest": "foo-new"},
+	}
+	h.containers["x"].State.Running = true
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerPullPolicy(dockerutil.PullAlways),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := container.Plan(h.client())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, plan.Actions(), []Action{
+		{Type: ActionPull, Container: "x", Image: "foo"},
+		{Type: ActionUnchanged, Container: "x", Image: "foo"},
+	})
+
+	err = plan.Apply(h.client())
+	ensure.DeepEqual(t, err, &DriftError{
+		Container: "x",
+		Reason:    `image "foo" now has id "foo-new" instead of "foo-id"`,
+	})
+	ensure.DeepEqual(t, h.calls, []string{"pull foo:latest"})
+}
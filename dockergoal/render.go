@@ -0,0 +1,384 @@
+package dockergoal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/facebookgo/stackerr"
+)
+
+// RenderOption configures how plans and diffs are rendered.
+type RenderOption func(o *renderOptions)
+
+type renderOptions struct {
+	color   bool
+	showEnv bool
+}
+
+// RenderColor colors the text output using ANSI escape codes, for terminals.
+func RenderColor() RenderOption {
+	return func(o *renderOptions) {
+		o.color = true
+	}
+}
+
+// RenderShowEnv shows the values of environment variables. They are redacted
+// by default since they often hold secrets.
+func RenderShowEnv() RenderOption {
+	return func(o *renderOptions) {
+		o.showEnv = true
+	}
+}
+
+func newRenderOptions(options []RenderOption) *renderOptions {
+	var o renderOptions
+	for _, f := range options {
+		f(&o)
+	}
+	return &o
+}
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// containerChange is what a plan does to a single container.
+type containerChange struct {
+	Container string       `json:"container"`
+	Change    ActionType   `json:"change"`
+	Image     string       `json:"image"`
+	Actions   []ActionType `json:"actions"`
+	Reasons   []string     `json:"reasons,omitempty"`
+	Fields    []FieldDiff  `json:"fields,omitempty"`
+	diff      *Diff
+	showEnv   bool
+}
+
+// planSummary counts the containers by change.
+type planSummary struct {
+	Create    int `json:"create"`
+	Recreate  int `json:"recreate"`
+	Start     int `json:"start"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (s planSummary) counts() string {
+	return fmt.Sprintf(
+		"%d to create, %d to recreate, %d to start, %d unchanged.",
+		s.Create,
+		s.Recreate,
+		s.Start,
+		s.Unchanged,
+	)
+}
+
+// changes groups the actions of the plan by container, in order.
+func (p *Plan) changes(o *renderOptions) ([]containerChange, planSummary) {
+	changes := []containerChange{}
+	var summary planSummary
+	for _, s := range p.steps {
+		c := containerChange{
+			Container: s.container.name,
+			Change:    ActionUnchanged,
+			Image:     s.image,
+			Actions:   []ActionType{},
+			showEnv:   o.showEnv,
+		}
+		for _, a := range s.actions {
+			c.Actions = append(c.Actions, a.Type)
+			switch a.Type {
+			case ActionCreate, ActionRecreate:
+				c.Change = a.Type
+			case ActionStart:
+				if c.Change == ActionUnchanged {
+					c.Change = ActionStart
+				}
+			}
+			if a.Type != ActionRecreate {
+				continue
+			}
+			if a.Diff == nil {
+				c.Reasons = a.Reasons
+				continue
+			}
+			c.diff = a.Diff
+			c.Reasons = a.Diff.Reasons()
+			c.Fields = redactDiff(a.Diff).Fields
+			if o.showEnv {
+				c.Reasons = a.Diff.reasons()
+				c.Fields = a.Diff.Fields
+			}
+		}
+
+		switch c.Change {
+		case ActionCreate:
+			summary.Create++
+		case ActionRecreate:
+			summary.Recreate++
+		case ActionStart:
+			summary.Start++
+		default:
+			summary.Unchanged++
+		}
+		changes = append(changes, c)
+	}
+	return changes, summary
+}
+
+// fieldKey returns what identifies a value of the field, which is the name of
+// an environment variable or the path in the container of a bind.
+func fieldKey(field, v string) string {
+	switch field {
+	case FieldEnv:
+		return strings.SplitN(v, "=", 2)[0]
+	case FieldBinds:
+		if parts := strings.Split(v, ":"); len(parts) > 1 {
+			return parts[1]
+		}
+	}
+	return v
+}
+
+// renderLine is a line of a rendered plan or diff. The sign shows what
+// happens to the thing the line describes.
+type renderLine struct {
+	sign  string
+	depth int
+	text  string
+}
+
+var changeSigns = map[ActionType]string{
+	ActionCreate:   "+",
+	ActionRecreate: "-/+",
+	ActionStart:    "~",
+}
+
+func (c *containerChange) lines() []renderLine {
+	lines := []renderLine{{
+		sign: changeSigns[c.Change],
+		text: fmt.Sprintf("%s (%s)", c.Container, c.Change),
+	}}
+	for _, a := range c.Actions {
+		if a == ActionPull || a == ActionBuild {
+			lines = append(lines, renderLine{depth: 1, text: fmt.Sprintf("%s %s", a, c.Image)})
+		}
+	}
+	if c.diff == nil {
+		for _, r := range c.Reasons {
+			lines = append(lines, renderLine{depth: 1, text: "# " + r})
+		}
+		return lines
+	}
+	return append(lines, diffLines(c.diff, c.showEnv)...)
+}
+
+func diffLines(d *Diff, showEnv bool) []renderLine {
+	var lines []renderLine
+	for _, f := range d.Fields {
+		lines = append(lines, renderLine{sign: "~", depth: 1, text: f.Field})
+		for _, v := range fieldValues(d, f) {
+			// values are compared before they're redacted
+			if f.Field == FieldEnv && !showEnv {
+				v.text = fieldKey(FieldEnv, v.text) + "=" + redacted
+			}
+			lines = append(lines, renderLine{sign: v.sign, depth: 2, text: v.text})
+		}
+	}
+	return lines
+}
+
+// fieldValues describes the change of the field. Lists which must match as a
+// whole are shown as such, while only the missing values and the ones they
+// replace are shown for lists which must include the desired values.
+func fieldValues(d *Diff, f FieldDiff) []renderLine {
+	switch f.Field {
+	case FieldImage:
+		desired := d.Image + " (not present)"
+		if len(f.Desired) > 0 {
+			desired = fmt.Sprintf("%s (%s)", d.Image, f.Desired[0])
+		}
+		return []renderLine{{sign: "-", text: f.Current[0]}, {sign: "+", text: desired}}
+	case FieldEnv, FieldBinds, FieldExposedPorts:
+		var lines []renderLine
+		for _, v := range f.Desired {
+			if containsStr(f.Current, v) {
+				continue
+			}
+			for _, c := range f.Current {
+				if fieldKey(f.Field, c) == fieldKey(f.Field, v) {
+					lines = append(lines, renderLine{sign: "-", text: c})
+				}
+			}
+			lines = append(lines, renderLine{sign: "+", text: v})
+		}
+		return lines
+	}
+	return []renderLine{
+		{sign: "-", text: fmt.Sprint(f.Current)},
+		{sign: "+", text: fmt.Sprint(f.Desired)},
+	}
+}
+
+func (l renderLine) color() string {
+	switch l.sign {
+	case "+":
+		return colorGreen
+	case "-":
+		return colorRed
+	case "~", "-/+":
+		return colorYellow
+	}
+	return ""
+}
+
+// writeText writes the lines with the signs after the indentation.
+func writeText(buf *bytes.Buffer, lines []renderLine, o *renderOptions) {
+	for _, l := range lines {
+		indent := strings.Repeat("    ", l.depth)
+		sign := fmt.Sprintf("%3s", l.sign)
+		if o.color && l.color() != "" {
+			sign = l.color() + sign + colorReset
+		}
+		fmt.Fprintf(buf, "%s%s %s\n", indent, sign, l.text)
+	}
+}
+
+func writeBuffer(w io.Writer, buf *bytes.Buffer) error {
+	_, err := w.Write(buf.Bytes())
+	return stackerr.Wrap(err)
+}
+
+// RenderPlanText writes the plan for humans, like:
+//
+//	-/+ db (recreate)
+//	      ~ env
+//	          - PASSWORD=(redacted)
+//	          + PASSWORD=(redacted)
+//
+//	  + web (create)
+//	        pull web
+//
+//	Plan: 1 to create, 1 to recreate, 0 to start, 0 unchanged.
+//
+// Containers which are left unchanged are only counted.
+func RenderPlanText(w io.Writer, p *Plan, options ...RenderOption) error {
+	o := newRenderOptions(options)
+	changes, summary := p.changes(o)
+	var buf bytes.Buffer
+	for _, c := range changes {
+		if c.Change == ActionUnchanged {
+			continue
+		}
+		writeText(&buf, c.lines(), o)
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "Plan: %s\n", summary.counts())
+	return writeBuffer(w, &buf)
+}
+
+// RenderDiffText writes the diff for humans, in the same format as
+// RenderPlanText.
+func RenderDiffText(w io.Writer, d *Diff, options ...RenderOption) error {
+	o := newRenderOptions(options)
+	lines := []renderLine{{sign: "~", text: d.Container}}
+	if d.Empty() {
+		lines = []renderLine{{text: d.Container + " (unchanged)"}}
+	}
+	var buf bytes.Buffer
+	writeText(&buf, append(lines, diffLines(d, o.showEnv)...), o)
+	return writeBuffer(w, &buf)
+}
+
+// RenderPlanJSON writes the plan as a JSON document, with the actions grouped
+// by container along with a summary. RenderColor has no effect.
+func RenderPlanJSON(w io.Writer, p *Plan, options ...RenderOption) error {
+	changes, summary := p.changes(newRenderOptions(options))
+	data, err := json.MarshalIndent(struct {
+		Containers []containerChange `json:"containers"`
+		Summary    planSummary       `json:"summary"`
+	}{changes, summary}, "", "  ")
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	buf := bytes.NewBuffer(data)
+	buf.WriteString("\n")
+	return writeBuffer(w, buf)
+}
+
+// RenderPlanMarkdown writes the plan as Markdown, for example to comment on a
+// pull request. It has a table of all the containers followed by the changes
+// in a diff block, which code hosts highlight. RenderColor has no effect.
+func RenderPlanMarkdown(w io.Writer, p *Plan, options ...RenderOption) error {
+	changes, summary := p.changes(newRenderOptions(options))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "**Plan:** %s\n\n", summary.counts())
+	buf.WriteString("| Container | Change | Image |\n")
+	buf.WriteString("| --- | --- | --- |\n")
+	for _, c := range changes {
+		fmt.Fprintf(&buf, "| %s | %s | `%s` |\n", c.Container, c.Change, c.Image)
+	}
+
+	var lines []renderLine
+	for _, c := range changes {
+		if c.Change != ActionUnchanged {
+			lines = append(lines, c.lines()...)
+		}
+	}
+	writeMarkdownDiff(&buf, lines)
+	return writeBuffer(w, &buf)
+}
+
+// writeMarkdownDiff writes the lines in a diff block, if there are any. The
+// sign goes first so the lines are highlighted.
+func writeMarkdownDiff(buf *bytes.Buffer, lines []renderLine) {
+	if len(lines) == 0 {
+		return
+	}
+	buf.WriteString("\n```diff\n")
+	for _, l := range lines {
+		line := fmt.Sprintf("%-3s %s%s", l.sign, strings.Repeat("    ", l.depth), l.text)
+		buf.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	buf.WriteString("```\n")
+}
+
+// RenderDiffJSON writes the diff as a JSON document. RenderColor has no
+// effect.
+func RenderDiffJSON(w io.Writer, d *Diff, options ...RenderOption) error {
+	data, err := marshalDiff(d, newRenderOptions(options).showEnv)
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return stackerr.Wrap(err)
+	}
+	buf.WriteString("\n")
+	return writeBuffer(w, &buf)
+}
+
+// RenderDiffMarkdown writes the diff as Markdown, with the differing fields
+// in a diff block like RenderPlanMarkdown. RenderColor has no effect.
+func RenderDiffMarkdown(w io.Writer, d *Diff, options ...RenderOption) error {
+	o := newRenderOptions(options)
+	var buf bytes.Buffer
+	if d.Empty() {
+		fmt.Fprintf(&buf, "**Diff:** %s is unchanged.\n", d.Container)
+		return writeBuffer(w, &buf)
+	}
+
+	fields := make([]string, len(d.Fields))
+	for i, f := range d.Fields {
+		fields[i] = f.Field
+	}
+	fmt.Fprintf(&buf, "**Diff:** %s differs in %s.\n", d.Container, strings.Join(fields, ", "))
+	lines := []renderLine{{sign: "~", text: d.Container}}
+	writeMarkdownDiff(&buf, append(lines, diffLines(d, o.showEnv)...))
+	return writeBuffer(w, &buf)
+}
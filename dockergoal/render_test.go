@@ -0,0 +1,463 @@
+package dockergoal
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func TestRenderPlanText(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"db": {
+				Id:     "db-old",
+				Image:  "old",
+				Config: &dockerclient.ContainerConfig{Env: []string{"PATH=/bin", "PASSWORD=a"}},
+			},
+			"cache":  {Id: "cache-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+			"worker": {Id: "worker-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{
+			"postgres:latest": "postgres-id",
+			"redis:latest":    "redis-id",
+		},
+	}
+	h.containers["cache"].State.Running = true
+
+	db, err := NewContainer(
+		ContainerName("db"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "postgres",
+			Env:   []string{"PASSWORD=b", "USER=app"},
+		}),
+		ContainerRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "web"}),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+	)
+	ensure.Nil(t, err)
+	cache, err := NewContainer(
+		ContainerName("cache"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+	worker, err := NewContainer(
+		ContainerName("worker"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := PlanGraph(h.client(), []*Container{web, db, cache, worker})
+	ensure.Nil(t, err)
+
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderPlanText(&buf, plan))
+	ensure.DeepEqual(t, buf.String(), `-/+ db (recreate)
+      ~ image
+          - old
+          + postgres (postgres-id)
+      ~ env
+          - PASSWORD=(redacted)
+          + PASSWORD=(redacted)
+          + USER=(redacted)
+
+  ~ worker (start)
+
+  + web (create)
+        pull web
+
+Plan: 1 to create, 1 to recreate, 1 to start, 1 unchanged.
+`)
+}
+
+func TestRenderPlanTextShowEnv(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"db": {
+				Id:     "db-old",
+				Image:  "old",
+				Config: &dockerclient.ContainerConfig{Env: []string{"PATH=/bin", "PASSWORD=a"}},
+			},
+			"cache":  {Id: "cache-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+			"worker": {Id: "worker-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{
+			"postgres:latest": "postgres-id",
+			"redis:latest":    "redis-id",
+		},
+	}
+	h.containers["cache"].State.Running = true
+
+	db, err := NewContainer(
+		ContainerName("db"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "postgres",
+			Env:   []string{"PASSWORD=b", "USER=app"},
+		}),
+		ContainerRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "web"}),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+	)
+	ensure.Nil(t, err)
+	cache, err := NewContainer(
+		ContainerName("cache"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+	worker, err := NewContainer(
+		ContainerName("worker"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := PlanGraph(h.client(), []*Container{web, db, cache, worker})
+	ensure.Nil(t, err)
+
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderPlanText(&buf, plan, RenderShowEnv()))
+	ensure.StringContains(t, buf.String(), `
+      ~ env
+          - PASSWORD=a
+          + PASSWORD=b
+          + USER=app
+`)
+}
+
+func TestRenderPlanTextForced(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"x": {Id: "x-old", Image: "foo-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{"foo:latest": "foo-id"},
+	}
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerForceRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	plan, err := container.Plan(h.client())
+	ensure.Nil(t, err)
+
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderPlanText(&buf, plan))
+	ensure.DeepEqual(t, buf.String(), `-/+ x (recreate)
+        # ContainerForceRemoveExisting is set
+
+Plan: 0 to create, 1 to recreate, 0 to start, 0 unchanged.
+`)
+}
+
+func TestRenderDiffTextColor(t *testing.T) {
+	d := &Diff{
+		Container: "x",
+		Image:     "foo",
+		Fields: []FieldDiff{
+			{Field: FieldCmd, Current: []string{"run"}, Desired: []string{"serve"}},
+		},
+	}
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderDiffText(&buf, d, RenderColor()))
+	ensure.DeepEqual(t, buf.String(), ""+
+		"\x1b[33m  ~\x1b[0m x\n"+
+		"    \x1b[33m  ~\x1b[0m cmd\n"+
+		"        \x1b[31m  -\x1b[0m [run]\n"+
+		"        \x1b[32m  +\x1b[0m [serve]\n")
+
+	buf.Reset()
+	ensure.Nil(t, RenderDiffText(&buf, &Diff{Container: "x"}))
+	ensure.DeepEqual(t, buf.String(), "    x (unchanged)\n")
+}
+
+func TestRenderPlanJSON(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"db": {
+				Id:     "db-old",
+				Image:  "old",
+				Config: &dockerclient.ContainerConfig{Env: []string{"PATH=/bin", "PASSWORD=a"}},
+			},
+			"cache":  {Id: "cache-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+			"worker": {Id: "worker-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{
+			"postgres:latest": "postgres-id",
+			"redis:latest":    "redis-id",
+		},
+	}
+	h.containers["cache"].State.Running = true
+
+	db, err := NewContainer(
+		ContainerName("db"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "postgres",
+			Env:   []string{"PASSWORD=b", "USER=app"},
+		}),
+		ContainerRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "web"}),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+	)
+	ensure.Nil(t, err)
+	cache, err := NewContainer(
+		ContainerName("cache"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+	worker, err := NewContainer(
+		ContainerName("worker"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := PlanGraph(h.client(), []*Container{web, db, cache, worker})
+	ensure.Nil(t, err)
+
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderPlanJSON(&buf, plan))
+	ensure.DeepEqual(t, buf.String(), `{
+  "containers": [
+    {
+      "container": "db",
+      "change": "recreate",
+      "image": "postgres",
+      "actions": [
+        "recreate",
+        "start"
+      ],
+      "reasons": [
+        "running with image \"old\" but desired image is \"postgres\" with id \"postgres-id\"",
+        "running with env [PATH=(redacted) PASSWORD=(redacted)] but desired env is [PASSWORD=(redacted) USER=(redacted)]"
+      ],
+      "fields": [
+        {
+          "field": "image",
+          "current": [
+            "old"
+          ],
+          "desired": [
+            "postgres-id"
+          ]
+        },
+        {
+          "field": "env",
+          "current": [
+            "PATH=(redacted)",
+            "PASSWORD=(redacted)"
+          ],
+          "desired": [
+            "PASSWORD=(redacted)",
+            "USER=(redacted)"
+          ]
+        }
+      ]
+    },
+    {
+      "container": "cache",
+      "change": "unchanged",
+      "image": "redis",
+      "actions": [
+        "unchanged"
+      ]
+    },
+    {
+      "container": "worker",
+      "change": "start",
+      "image": "redis",
+      "actions": [
+        "start"
+      ]
+    },
+    {
+      "container": "web",
+      "change": "create",
+      "image": "web",
+      "actions": [
+        "pull",
+        "create",
+        "start"
+      ]
+    }
+  ],
+  "summary": {
+    "create": 1,
+    "recreate": 1,
+    "start": 1,
+    "unchanged": 1
+  }
+}
+`)
+}
+
+func TestRenderPlanMarkdown(t *testing.T) {
+	h := &planHost{
+		containers: map[string]*dockerclient.ContainerInfo{
+			"db": {
+				Id:     "db-old",
+				Image:  "old",
+				Config: &dockerclient.ContainerConfig{Env: []string{"PATH=/bin", "PASSWORD=a"}},
+			},
+			"cache":  {Id: "cache-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+			"worker": {Id: "worker-old", Image: "redis-id", Config: &dockerclient.ContainerConfig{}},
+		},
+		images: map[string]string{
+			"postgres:latest": "postgres-id",
+			"redis:latest":    "redis-id",
+		},
+	}
+	h.containers["cache"].State.Running = true
+
+	db, err := NewContainer(
+		ContainerName("db"),
+		ContainerConfig(&dockerclient.ContainerConfig{
+			Image: "postgres",
+			Env:   []string{"PASSWORD=b", "USER=app"},
+		}),
+		ContainerRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "web"}),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+	)
+	ensure.Nil(t, err)
+	cache, err := NewContainer(
+		ContainerName("cache"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+	worker, err := NewContainer(
+		ContainerName("worker"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}),
+	)
+	ensure.Nil(t, err)
+
+	plan, err := PlanGraph(h.client(), []*Container{web, db, cache, worker})
+	ensure.Nil(t, err)
+
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderPlanMarkdown(&buf, plan))
+	ensure.DeepEqual(t, buf.String(), "**Plan:** 1 to create, 1 to recreate, 1 to start, 1 unchanged.\n"+`
+| Container | Change | Image |
+| --- | --- | --- |
+| db | recreate | `+"`postgres`"+` |
+| cache | unchanged | `+"`redis`"+` |
+| worker | start | `+"`redis`"+` |
+| web | create | `+"`web`"+` |
+
+`+"```diff"+`
+-/+ db (recreate)
+~       image
+-           old
++           postgres (postgres-id)
+~       env
+-           PASSWORD=(redacted)
++           PASSWORD=(redacted)
++           USER=(redacted)
+~   worker (start)
++   web (create)
+        pull web
+`+"```"+`
+`)
+}
+
+func TestRenderDiffJSON(t *testing.T) {
+	d := &Diff{
+		Container: "x",
+		Image:     "foo",
+		Fields: []FieldDiff{
+			{Field: FieldEnv, Current: []string{"PASSWORD=a"}, Desired: []string{"PASSWORD=b"}},
+		},
+	}
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderDiffJSON(&buf, d))
+	ensure.DeepEqual(t, buf.String(), `{
+  "container": "x",
+  "image": "foo",
+  "fields": [
+    {
+      "field": "env",
+      "current": [
+        "PASSWORD=(redacted)"
+      ],
+      "desired": [
+        "PASSWORD=(redacted)"
+      ]
+    }
+  ]
+}
+`)
+
+	buf.Reset()
+	ensure.Nil(t, RenderDiffJSON(&buf, d, RenderShowEnv()))
+	ensure.StringContains(t, buf.String(), `"PASSWORD=b"`)
+
+	buf.Reset()
+	ensure.Nil(t, RenderDiffJSON(&buf, &Diff{Container: "x", Image: "foo"}))
+	ensure.DeepEqual(t, buf.String(), `{
+  "container": "x",
+  "image": "foo",
+  "fields": []
+}
+`)
+}
+
+func TestMarshalDiffRedacted(t *testing.T) {
+	d := &Diff{
+		Container: "x",
+		Fields: []FieldDiff{
+			{Field: FieldEnv, Current: []string{"PASSWORD=a"}, Desired: []string{"PASSWORD=b"}},
+		},
+	}
+	data, err := json.Marshal(d)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(data), `{"container":"x","image":"",`+
+		`"fields":[{"field":"env","current":["PASSWORD=(redacted)"],"desired":["PASSWORD=(redacted)"]}]}`)
+	ensure.DeepEqual(t, d.Fields[0].Desired, []string{"PASSWORD=b"})
+
+	err = &MismatchError{Diff: d}
+	ensure.DeepEqual(t, err.Error(),
+		`container "x" running with env [PASSWORD=(redacted)] but desired env is [PASSWORD=(redacted)]`)
+}
+
+func TestRenderDiffMarkdown(t *testing.T) {
+	d := &Diff{
+		Container: "x",
+		Image:     "foo",
+		Fields: []FieldDiff{
+			{Field: FieldCmd, Current: []string{"run"}, Desired: []string{"serve"}},
+			{Field: FieldEnv, Current: []string{"PASSWORD=a"}, Desired: []string{"PASSWORD=b"}},
+		},
+	}
+	var buf bytes.Buffer
+	ensure.Nil(t, RenderDiffMarkdown(&buf, d))
+	ensure.DeepEqual(t, buf.String(), "**Diff:** x differs in cmd, env.\n"+`
+`+"```diff"+`
+~   x
+~       cmd
+-           [run]
++           [serve]
+~       env
+-           PASSWORD=(redacted)
++           PASSWORD=(redacted)
+`+"```"+`
+`)
+
+	buf.Reset()
+	ensure.Nil(t, RenderDiffMarkdown(&buf, &Diff{Container: "x"}))
+	ensure.DeepEqual(t, buf.String(), "**Diff:** x is unchanged.\n")
+}
@@ -0,0 +1,200 @@
+package dockergoal
+
+import (
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	defaultStopSignal      = "SIGTERM"
+	defaultStopGracePeriod = 10 * time.Second
+)
+
+var (
+	// stopPollInterval is how often Stop checks if the container exited.
+	stopPollInterval = 100 * time.Millisecond
+
+	// killWaitTimeout is how long Stop waits for a killed container to exit.
+	killWaitTimeout = 10 * time.Second
+)
+
+// ContainerStopSignal specifies the signal sent to stop the container. It
+// defaults to SIGTERM.
+func ContainerStopSignal(signal string) ContainerOption {
+	return func(c *Container) error {
+		c.stopSignal = signal
+		return nil
+	}
+}
+
+// ContainerStopGracePeriod specifies how long the container has to exit after
+// the stop signal before it's killed. It defaults to 10 seconds, a zero grace
+// period kills the container right after the stop signal.
+func ContainerStopGracePeriod(d time.Duration) ContainerOption {
+	return func(c *Container) error {
+		c.stopGracePeriod = d
+		c.stopGracePeriodSet = true
+		return nil
+	}
+}
+
+// Stop sends the stop signal to the container and waits for the grace period
+// for it to exit, killing it otherwise. It reports if the container had to be
+// killed. Containers which do not exist or aren't running are left alone.
+func (c *Container) Stop(docker dockerclient.Client) (bool, error) {
+	ci, err := docker.InspectContainer(c.name)
+	if err != nil {
+		if err == dockerclient.ErrNotFound {
+			return false, nil
+		}
+		return false, stackerr.Wrap(err)
+	}
+	if !ci.State.Running {
+		return false, nil
+	}
+
+	signal := c.stopSignal
+	if signal == "" {
+		signal = defaultStopSignal
+	}
+	grace := c.stopGracePeriod
+	if !c.stopGracePeriodSet {
+		grace = defaultStopGracePeriod
+	}
+
+	if err := docker.KillContainer(ci.Id, signal); err != nil {
+		return false, stackerr.Wrap(err)
+	}
+	exited, err := waitExited(docker, ci.Id, grace)
+	if exited || err != nil {
+		return false, err
+	}
+
+	if err := docker.KillContainer(ci.Id, "SIGKILL"); err != nil {
+		return false, stackerr.Wrap(err)
+	}
+	// the container is only gone once the daemon noticed, removing it before
+	// would fail
+	exited, err = waitExited(docker, ci.Id, killWaitTimeout)
+	if err != nil {
+		return true, err
+	}
+	if !exited {
+		return true, stackerr.Newf("container %q did not exit after SIGKILL", c.name)
+	}
+	return true, nil
+}
+
+// waitExited waits up to timeout for the container to stop running, and
+// reports if it did. A container which was removed in the meantime, for
+// example with --rm, exited too.
+func waitExited(docker dockerclient.Client, id string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := docker.InspectContainer(id)
+		if err != nil {
+			if err == dockerclient.ErrNotFound {
+				return true, nil
+			}
+			return false, stackerr.Wrap(err)
+		}
+		if !current.State.Running {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// TeardownOption configures Teardown.
+type TeardownOption func(o *teardownOptions)
+
+type teardownOptions struct {
+	remove bool
+}
+
+// TeardownRemove makes Teardown remove the containers along with their
+// anonymous volumes once they're stopped.
+func TeardownRemove() TeardownOption {
+	return func(o *teardownOptions) {
+		o.remove = true
+	}
+}
+
+// TeardownResult reports what Teardown did.
+type TeardownResult struct {
+	// Stopped are the names of the containers which were running, in the
+	// order they were stopped.
+	Stopped []string
+
+	// Killed are the names of the containers which did not exit within their
+	// grace period and were killed.
+	Killed []string
+
+	// Removed are the names of the containers which were removed.
+	Removed []string
+}
+
+// Teardown stops all the specified containers in the reverse order of
+// ApplyGraph, so containers are stopped before the ones they link to. Each
+// container is stopped as in Container.Stop. The result is returned along with
+// an error, reporting what was done before the error.
+func Teardown(
+	docker dockerclient.Client,
+	containers []*Container,
+	options ...TeardownOption,
+) (*TeardownResult, error) {
+	var o teardownOptions
+	for _, f := range options {
+		f(&o)
+	}
+
+	var ordered []*Container
+	err := walkGraph(containers, func(c *Container) error {
+		ordered = append(ordered, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result TeardownResult
+	for i := len(ordered) - 1; i >= 0; i-- {
+		c := ordered[i]
+		ci, err := docker.InspectContainer(c.name)
+		if err != nil {
+			if err == dockerclient.ErrNotFound {
+				continue
+			}
+			return &result, stackerr.Wrap(err)
+		}
+
+		if ci.State.Running {
+			killed, err := c.Stop(docker)
+			if err != nil {
+				return &result, err
+			}
+			result.Stopped = append(result.Stopped, c.name)
+			if killed {
+				result.Killed = append(result.Killed, c.name)
+			}
+		}
+
+		if o.remove {
+			err := docker.RemoveContainer(ci.Id, false, true)
+			if err == dockerclient.ErrNotFound {
+				// removed in the meantime, for example with --rm
+				continue
+			}
+			if err != nil {
+				return &result, stackerr.Wrap(err)
+			}
+			result.Removed = append(result.Removed, c.name)
+		}
+	}
+	return &result, nil
+}
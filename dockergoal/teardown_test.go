@@ -0,0 +1,235 @@
+package dockergoal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+func TestTeardown(t *testing.T) {
+	defer func(d time.Duration) { stopPollInterval = d }(stopPollInterval)
+	stopPollInterval = time.Millisecond
+
+	db, err := NewContainer(
+		ContainerName("db"),
+		ContainerStopGracePeriod(time.Millisecond),
+	)
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+		ContainerStopSignal("SIGQUIT"),
+	)
+	ensure.Nil(t, err)
+	cache, err := NewContainer(ContainerName("cache"))
+	ensure.Nil(t, err)
+
+	var calls []string
+	running := map[string]bool{"web": true, "db": true, "cache": false}
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			r, ok := running[name]
+			if !ok {
+				return nil, dockerclient.ErrNotFound
+			}
+			ci := &dockerclient.ContainerInfo{Id: name}
+			ci.State.Running = r
+			return ci, nil
+		},
+		killContainer: func(id, signal string) error {
+			calls = append(calls, "kill "+id+" "+signal)
+			// web exits on its stop signal, db only once it's killed
+			if id == "web" || signal == "SIGKILL" {
+				running[id] = false
+			}
+			return nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			ensure.False(t, force)
+			ensure.True(t, volumes)
+			ensure.False(t, running[id])
+			calls = append(calls, "remove "+id)
+			delete(running, id)
+			return nil
+		},
+	}
+
+	result, err := Teardown(client, []*Container{web, db, cache}, TeardownRemove())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result, &TeardownResult{
+		Stopped: []string{"web", "db"},
+		Killed:  []string{"db"},
+		Removed: []string{"web", "cache", "db"},
+	})
+	ensure.DeepEqual(t, calls, []string{
+		"kill web SIGQUIT",
+		"remove web",
+		"remove cache",
+		"kill db SIGTERM",
+		"kill db SIGKILL",
+		"remove db",
+	})
+	ensure.DeepEqual(t, len(running), 0)
+}
+
+func TestTeardownWithoutRemove(t *testing.T) {
+	db, err := NewContainer(ContainerName("db"))
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+		ContainerStopSignal("SIGQUIT"),
+	)
+	ensure.Nil(t, err)
+
+	var calls []string
+	running := true
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			if name != "web" {
+				return nil, dockerclient.ErrNotFound
+			}
+			ci := &dockerclient.ContainerInfo{Id: name}
+			ci.State.Running = running
+			return ci, nil
+		},
+		killContainer: func(id, signal string) error {
+			calls = append(calls, "kill "+id+" "+signal)
+			running = false
+			return nil
+		},
+	}
+
+	result, err := Teardown(client, []*Container{web, db})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result, &TeardownResult{Stopped: []string{"web"}})
+	ensure.DeepEqual(t, calls, []string{"kill web SIGQUIT"})
+}
+
+func TestTeardownKillError(t *testing.T) {
+	db, err := NewContainer(ContainerName("db"))
+	ensure.Nil(t, err)
+	web, err := NewContainer(
+		ContainerName("web"),
+		ContainerHostConfig(&dockerclient.HostConfig{Links: []string{"db:db"}}),
+	)
+	ensure.Nil(t, err)
+
+	givenErr := errors.New("")
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: name}
+			ci.State.Running = true
+			return ci, nil
+		},
+		killContainer: func(id, signal string) error {
+			return givenErr
+		},
+	}
+	result, err := Teardown(client, []*Container{web, db})
+	ensure.True(t, stackerr.HasUnderlying(err, stackerr.Equals(givenErr)))
+	ensure.DeepEqual(t, result, &TeardownResult{})
+}
+
+func TestStopNotRunning(t *testing.T) {
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: name}, nil
+		},
+	}
+	c, err := NewContainer(ContainerName("x"))
+	ensure.Nil(t, err)
+	killed, err := c.Stop(client)
+	ensure.Nil(t, err)
+	ensure.False(t, killed)
+}
+
+func TestStopZeroGracePeriod(t *testing.T) {
+	var calls []string
+	running := true
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: name}
+			ci.State.Running = running
+			return ci, nil
+		},
+		killContainer: func(id, signal string) error {
+			calls = append(calls, "kill "+id+" "+signal)
+			running = signal != "SIGKILL"
+			return nil
+		},
+	}
+	c, err := NewContainer(ContainerName("x"), ContainerStopGracePeriod(0))
+	ensure.Nil(t, err)
+	start := time.Now()
+	killed, err := c.Stop(client)
+	ensure.Nil(t, err)
+	ensure.True(t, killed)
+	ensure.True(t, time.Since(start) < defaultStopGracePeriod)
+	ensure.DeepEqual(t, calls, []string{"kill x SIGTERM", "kill x SIGKILL"})
+}
+
+func TestTeardownWaitsForKill(t *testing.T) {
+	defer func(d time.Duration) { stopPollInterval = d }(stopPollInterval)
+	stopPollInterval = time.Millisecond
+
+	db, err := NewContainer(
+		ContainerName("db"),
+		ContainerStopGracePeriod(time.Millisecond),
+	)
+	ensure.Nil(t, err)
+
+	// the daemon takes a few inspects to notice the container died
+	running := true
+	dying := 0
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			if dying > 0 {
+				if dying--; dying == 0 {
+					running = false
+				}
+			}
+			ci := &dockerclient.ContainerInfo{Id: name}
+			ci.State.Running = running
+			return ci, nil
+		},
+		killContainer: func(id, signal string) error {
+			if signal == "SIGKILL" {
+				dying = 3
+			}
+			return nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			ensure.False(t, running)
+			return nil
+		},
+	}
+
+	result, err := Teardown(client, []*Container{db}, TeardownRemove())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result, &TeardownResult{
+		Stopped: []string{"db"},
+		Killed:  []string{"db"},
+		Removed: []string{"db"},
+	})
+}
+
+func TestTeardownRemovedInTheMeantime(t *testing.T) {
+	cache, err := NewContainer(ContainerName("cache"))
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: name}, nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			return dockerclient.ErrNotFound
+		},
+	}
+	result, err := Teardown(client, []*Container{cache}, TeardownRemove())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result, &TeardownResult{})
+}
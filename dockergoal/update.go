@@ -0,0 +1,166 @@
+package dockergoal
+
+import (
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// A DigestResolver returns the digest the registry currently serves for the
+// given image reference, such as "sha256:...". The Digest method of a
+// registry.Client is one.
+type DigestResolver func(imageName string, auth *dockerclient.AuthConfig) (string, error)
+
+// UpdateOption configures UpdateGraph.
+type UpdateOption func(o *updateOptions)
+
+type updateOptions struct {
+	resolver DigestResolver
+}
+
+// UpdateDigestResolver makes UpdateGraph ask the registry for the digest of
+// each tag before pulling. If the running container already uses an image
+// with that digest the pull is skipped entirely.
+func UpdateDigestResolver(r DigestResolver) UpdateOption {
+	return func(o *updateOptions) {
+		o.resolver = r
+	}
+}
+
+// UpdateGraph refreshes the images of all the specified containers and
+// recreates the containers whose image changed, for example because a moving
+// tag such as "latest" was pushed again. Containers which do not exist yet are
+// created. Containers are visited in the same order as ApplyGraph. It returns
+// the names of the containers which were created or recreated. Containers
+// using ContainerBuild are rebuilt instead, and recreated if the context
+// changed. Like Apply, existing containers are only recreated with
+// ContainerRemoveExisting, otherwise a *MismatchError is returned, and stopped
+// containers are started. Images are always pulled unless the container specifies a
+// ContainerPullPolicy, so with dockerutil.PullNever a container is only
+// recreated if its image was replaced locally, for example by a bundle.
+func UpdateGraph(
+	docker dockerclient.Client,
+	containers []*Container,
+	options ...UpdateOption,
+) ([]string, error) {
+	var o updateOptions
+	for _, f := range options {
+		f(&o)
+	}
+
+	var updated []string
+	err := walkGraph(containers, func(c *Container) error {
+		changed, err := c.update(docker, &o)
+		if err != nil {
+			return err
+		}
+		if changed {
+			updated = append(updated, c.name)
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (c *Container) update(docker dockerclient.Client, o *updateOptions) (bool, error) {
+	// refresh the image unless the container says otherwise, in which case
+	// the local image is compared
+	pullPolicy := dockerutil.PullAlways
+	if c.pullPolicySet {
+		pullPolicy = c.pullPolicy
+	}
+
+	ci, err := docker.InspectContainer(c.name)
+	if err != nil {
+		// unknown error, bail
+		if err != dockerclient.ErrNotFound {
+			return false, stackerr.Wrap(err)
+		}
+
+		// container does not exist, create it
+		return true, c.apply(docker, pullPolicy)
+	}
+
+	if c.build != nil {
+		if err := c.buildImage(docker); err != nil {
+			return false, err
+		}
+	} else if o.resolver != nil {
+		current, err := c.hasRegistryDigest(docker, ci, o.resolver)
+		if err != nil {
+			return false, err
+		}
+		if current {
+			return false, c.startStopped(docker, ci)
+		}
+	}
+
+	desiredImageID, err := dockerutil.ImageID(
+		docker,
+		c.config().Image,
+		c.authConfig,
+		c.pullOptions(pullPolicy)...,
+	)
+	if err != nil {
+		return false, err
+	}
+	if ci.Image == desiredImageID {
+		return false, c.startStopped(docker, ci)
+	}
+
+	// the image changed, replace the container using the freshly pulled image
+	// if we're allowed to
+	if !c.removeExisting && !c.forceRemoveExisting {
+		return false, &MismatchError{Diff: c.diff(ci, c.config().Image, desiredImageID)}
+	}
+	if err := docker.RemoveContainer(ci.Id, true, false); err != nil {
+		return false, stackerr.Wrap(err)
+	}
+	if pullPolicy == dockerutil.PullAlways {
+		pullPolicy = dockerutil.PullIfNotPresent
+	}
+	return true, c.apply(docker, pullPolicy)
+}
+
+// startStopped starts the existing container if it isn't running.
+func (c *Container) startStopped(docker dockerclient.Client, ci *dockerclient.ContainerInfo) error {
+	if ci.State.Running {
+		return nil
+	}
+	return c.start(docker, ci, false)
+}
+
+// hasRegistryDigest reports if the image of the existing container carries the
+// digest the registry currently serves for the desired image. With a rewriter
+// the registries of the rewritten images are asked in order, until one of
+// them answers.
+func (c *Container) hasRegistryDigest(
+	docker dockerclient.Client,
+	current *dockerclient.ContainerInfo,
+	resolver DigestResolver,
+) (bool, error) {
+	names := []string{c.containerConfig.Image}
+	if c.rewriter != nil {
+		var err error
+		if names, err = c.rewriter.Rewrite(c.containerConfig.Image); err != nil {
+			return false, err
+		}
+	}
+
+	var err error
+	for _, name := range names {
+		var digest string
+		if digest, err = resolver(name, c.authConfig); err != nil {
+			continue
+		}
+		ref, err := dockerutil.ParseReference(name)
+		if err != nil {
+			return false, err
+		}
+		pinned := *ref
+		pinned.Tag = ""
+		pinned.Digest = digest
+		return dockerutil.ImageHasDigest(docker, current.Image, pinned.String())
+	}
+	return false, err
+}
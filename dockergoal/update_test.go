@@ -0,0 +1,261 @@
+package dockergoal
+
+import (
+	"testing"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func TestUpdateGraphUnchanged(t *testing.T) {
+	const imageID = "i"
+	var pullCalls int
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: "y", Image: imageID}
+			ci.State.Running = true
+			return ci, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			pullCalls++
+			return nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{Id: imageID, RepoTags: []string{"foo:latest"}}}, nil
+		},
+	}
+	updated, err := UpdateGraph(client, []*Container{container})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(updated), 0)
+	ensure.DeepEqual(t, pullCalls, 1)
+}
+
+func TestUpdateGraphChanged(t *testing.T) {
+	const givenID = "y"
+	var inspectCalls, removeCalls, createCalls, startCalls int
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerRemoveExisting(),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			inspectCalls++
+			switch inspectCalls {
+			case 1:
+				return &dockerclient.ContainerInfo{Id: givenID, Image: "old"}, nil
+			case 2:
+				return nil, dockerclient.ErrNotFound
+			case 3:
+				return &dockerclient.ContainerInfo{Id: givenID, Image: "new"}, nil
+			}
+			panic("not reached")
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			return nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{Id: "new", RepoTags: []string{"foo:latest"}}}, nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			removeCalls++
+			ensure.DeepEqual(t, id, givenID)
+			return nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			createCalls++
+			return givenID, nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			startCalls++
+			return nil
+		},
+	}
+	updated, err := UpdateGraph(client, []*Container{container})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, updated, []string{"x"})
+	ensure.DeepEqual(t, removeCalls, 1)
+	ensure.DeepEqual(t, createCalls, 1)
+	ensure.DeepEqual(t, startCalls, 1)
+}
+
+func TestUpdateGraphResolverCurrent(t *testing.T) {
+	const imageID = "i"
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: "y", Image: imageID}
+			ci.State.Running = true
+			return ci, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					Id:          imageID,
+					RepoTags:    []string{"foo:latest"},
+					RepoDigests: []string{"foo@" + testDigest},
+				},
+			}, nil
+		},
+	}
+	resolver := func(imageName string, auth *dockerclient.AuthConfig) (string, error) {
+		ensure.DeepEqual(t, imageName, "foo")
+		return testDigest, nil
+	}
+	updated, err := UpdateGraph(client, []*Container{container}, UpdateDigestResolver(resolver))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(updated), 0)
+}
+
+func TestUpdateGraphPullNever(t *testing.T) {
+	var calls []string
+	current := &dockerclient.ContainerInfo{Id: "y", Image: "old"}
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerRemoveExisting(),
+		ContainerPullPolicy(dockerutil.PullNever),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			if current == nil {
+				return nil, dockerclient.ErrNotFound
+			}
+			return current, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{Id: "new", RepoTags: []string{"foo:latest"}}}, nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			calls = append(calls, "remove "+id)
+			current = nil
+			return nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			calls = append(calls, "create "+name)
+			current = &dockerclient.ContainerInfo{Id: "z", Image: "new"}
+			return "z", nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			calls = append(calls, "start "+id)
+			current.State.Running = true
+			return nil
+		},
+	}
+	updated, err := UpdateGraph(client, []*Container{container})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, updated, []string{"x"})
+	ensure.DeepEqual(t, calls, []string{"remove y", "create x", "start z"})
+
+	calls = nil
+	updated, err = UpdateGraph(client, []*Container{container})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(updated), 0)
+	ensure.DeepEqual(t, calls, []string(nil))
+}
+
+func TestUpdateGraphMismatch(t *testing.T) {
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: "y", Image: "old"}, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			return nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{Id: "new", RepoTags: []string{"foo:latest"}}}, nil
+		},
+	}
+	updated, err := UpdateGraph(client, []*Container{container})
+	mismatch, ok := err.(*MismatchError)
+	ensure.True(t, ok, err)
+	ensure.DeepEqual(t, mismatch.Diff.Fields, []FieldDiff{
+		{Field: FieldImage, Current: []string{"old"}, Desired: []string{"new"}},
+	})
+	ensure.DeepEqual(t, len(updated), 0)
+}
+
+func TestUpdateGraphStartsStopped(t *testing.T) {
+	var started []string
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: "y", Image: "i"}, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			return nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{{Id: "i", RepoTags: []string{"foo:latest"}}}, nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			started = append(started, id)
+			return nil
+		},
+	}
+	updated, err := UpdateGraph(client, []*Container{container})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(updated), 0)
+	ensure.DeepEqual(t, started, []string{"y"})
+}
+
+func TestUpdateGraphResolverRewritten(t *testing.T) {
+	const imageID = "i"
+	container, err := NewContainer(
+		ContainerName("x"),
+		ContainerConfig(&dockerclient.ContainerConfig{Image: "foo"}),
+		ContainerRewriter(&dockerutil.Rewriter{
+			Rules: []dockerutil.RewriteRule{{
+				Prefix:       "docker.io/",
+				Replacements: []string{"mirror.example.com/", "docker.io/"},
+			}},
+		}),
+	)
+	ensure.Nil(t, err)
+	client := &mockClient{
+		inspectContainer: func(name string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: "y", Image: imageID}
+			ci.State.Running = true
+			return ci, nil
+		},
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{
+					Id:          imageID,
+					RepoTags:    []string{"mirror.example.com/library/foo:latest"},
+					RepoDigests: []string{"mirror.example.com/library/foo@" + testDigest},
+				},
+			}, nil
+		},
+	}
+	var resolved []string
+	resolver := func(imageName string, auth *dockerclient.AuthConfig) (string, error) {
+		resolved = append(resolved, imageName)
+		return testDigest, nil
+	}
+	updated, err := UpdateGraph(client, []*Container{container}, UpdateDigestResolver(resolver))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(updated), 0)
+	ensure.DeepEqual(t, resolved, []string{"mirror.example.com/library/foo:latest"})
+}
@@ -0,0 +1,141 @@
+package dockerutil
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/facebookgo/stackerr"
+)
+
+// ignorePattern is a line of a .dockerignore file.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	exclude bool
+}
+
+// ignoreMatcher decides which files of a build context are sent to the
+// daemon, following the rules of .dockerignore files: patterns use
+// filepath.Match syntax plus "**" for any number of directories, a leading
+// "!" re-includes files, and the last matching pattern wins. A pattern
+// matching a directory matches everything below it.
+type ignoreMatcher struct {
+	patterns []ignorePattern
+}
+
+// readDockerignore reads the .dockerignore file in the context directory. A
+// missing file ignores nothing.
+func readDockerignore(dir string) (*ignoreMatcher, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ignoreMatcher{}, nil
+		}
+		return nil, stackerr.Wrap(err)
+	}
+	defer f.Close()
+	return parseDockerignore(f)
+}
+
+func parseDockerignore(r io.Reader) (*ignoreMatcher, error) {
+	var m ignoreMatcher
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.exclude = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = filepath.ToSlash(filepath.Clean(line))
+		line = strings.TrimPrefix(line, "/")
+		re, err := ignoreRegexp(line)
+		if err != nil {
+			return nil, stackerr.Newf("invalid .dockerignore pattern %q: %s", line, err)
+		}
+		p.re = re
+		m.patterns = append(m.patterns, p)
+	}
+	if err := s.Err(); err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	return &m, nil
+}
+
+// ignoreRegexp translates a pattern to a regular expression matching the
+// slash separated path of a file or any of its parent directories.
+func ignoreRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				// "**/" also matches no directory at all
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, stackerr.New("unterminated character class")
+			}
+			// the class syntax of filepath.Match is valid in a regexp, except
+			// for the shell style negation "[!...]"
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				// a negated class still never matches a separator
+				class = "^/" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("(/.*)?$")
+	return regexp.Compile(b.String())
+}
+
+// ignored reports if the file with the given slash separated path relative to
+// the context directory is excluded from the context.
+func (m *ignoreMatcher) ignored(name string) bool {
+	ignored := false
+	for _, p := range m.patterns {
+		if p.re.MatchString(name) {
+			ignored = !p.exclude
+		}
+	}
+	return ignored
+}
+
+// mayReinclude reports if a file below the ignored directory may be included
+// again by an exclusion, in which case the directory has to be walked.
+func (m *ignoreMatcher) mayReinclude() bool {
+	for _, p := range m.patterns {
+		if p.exclude {
+			return true
+		}
+	}
+	return false
+}
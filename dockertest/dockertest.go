@@ -0,0 +1,152 @@
+// Package dockertest provides containers which live for the duration of a
+// test.
+package dockertest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/dockerutil/dockergoal"
+	"github.com/samalba/dockerclient"
+)
+
+// invalidNameChars matches the characters not allowed in container names.
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// A Container is a container started for a test.
+type Container struct {
+	// ID is the ID of the container.
+	ID string
+
+	// Name is the unique name the container was created with.
+	Name string
+
+	// Endpoints maps the bindings of the container, such as "6379/tcp", to
+	// their host address as returned by dockerutil.BindingAddr.
+	Endpoints map[string]string
+}
+
+// Start creates and starts a container with a unique name derived from the
+// name of the test. The container is removed along with its volumes when the
+// test and its subtests finish, and its logs are written to the test log if
+// the test failed. Any error fails the test immediately.
+func Start(
+	t testing.TB,
+	d dockerclient.Client,
+	config *dockerclient.ContainerConfig,
+	hostConfig *dockerclient.HostConfig,
+) *Container {
+	t.Helper()
+	name := uniqueName(t)
+	id, err := dockerutil.CreateWithPull(d, config, name, nil)
+	if err != nil {
+		t.Fatalf("creating container for %s: %s", config.Image, err)
+	}
+	cleanup(t, d, id, name, config.Tty)
+
+	if err := d.StartContainer(id, hostConfig); err != nil {
+		t.Fatalf("starting container %s: %s", name, err)
+	}
+	return started(t, d, id, name)
+}
+
+// StartGoal is like Start for a dockergoal container. The options must not
+// include a dockergoal.ContainerName, the unique name is used instead.
+func StartGoal(t testing.TB, d dockerclient.Client, options ...dockergoal.ContainerOption) *Container {
+	t.Helper()
+	name := uniqueName(t)
+	options = append(options, dockergoal.ContainerName(name))
+	c, err := dockergoal.NewContainer(options...)
+	if err != nil {
+		t.Fatalf("creating container goal: %s", err)
+	}
+
+	applyErr := c.Apply(d)
+
+	// the container may exist even if applying failed, for example when it
+	// failed to start
+	ci, err := d.InspectContainer(name)
+	if err == nil {
+		cleanup(t, d, ci.Id, name, ci.Config != nil && ci.Config.Tty)
+	}
+	if applyErr != nil {
+		t.Fatalf("applying container %s: %s", name, applyErr)
+	}
+	if err != nil {
+		t.Fatalf("inspecting container %s: %s", name, err)
+	}
+	return started(t, d, ci.Id, name)
+}
+
+// started resolves the endpoints of the started container.
+func started(t testing.TB, d dockerclient.Client, id, name string) *Container {
+	t.Helper()
+	ci, err := d.InspectContainer(id)
+	if err != nil {
+		t.Fatalf("inspecting container %s: %s", name, err)
+	}
+
+	c := &Container{
+		ID:        id,
+		Name:      name,
+		Endpoints: map[string]string{},
+	}
+	for binding, ports := range ci.NetworkSettings.Ports {
+		if len(ports) == 0 {
+			continue
+		}
+		addr, err := dockerutil.BindingAddr(d, id, binding)
+		if err != nil {
+			t.Fatalf("resolving binding %s of container %s: %s", binding, name, err)
+		}
+		c.Endpoints[binding] = addr
+	}
+	return c
+}
+
+// cleanup registers the removal of the container, logging its output first
+// if the test failed.
+func cleanup(t testing.TB, d dockerclient.Client, id, name string, tty bool) {
+	t.Cleanup(func() {
+		if t.Failed() {
+			logContainer(t, d, id, name, tty)
+		}
+		if err := d.RemoveContainer(id, true, true); err != nil && err != dockerclient.ErrNotFound {
+			t.Errorf("removing container %s: %s", name, err)
+		}
+	})
+}
+
+// logContainer writes the logs of the container to the test log, a line at a
+// time.
+func logContainer(t testing.TB, d dockerclient.Client, id, name string, tty bool) {
+	logs, err := d.ContainerLogs(id, &dockerclient.LogOptions{Stdout: true, Stderr: true})
+	if err != nil {
+		t.Logf("fetching logs of container %s: %s", name, err)
+		return
+	}
+	defer logs.Close()
+
+	err = dockerutil.ReadLogs(logs, tty, false, func(e dockerutil.LogEntry) error {
+		t.Logf("%s %s: %s", name, e.Stream, e.Line)
+		return nil
+	})
+	if err != nil {
+		t.Logf("reading logs of container %s: %s", name, err)
+	}
+}
+
+// uniqueName returns a container name derived from the name of the test.
+func uniqueName(t testing.TB) string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		t.Fatalf("generating container name: %s", err)
+	}
+	name := invalidNameChars.ReplaceAllString(t.Name(), "_")
+	name = strings.Trim(name, "_.-")
+	return "dockertest_" + name + "_" + hex.EncodeToString(b[:])
+}
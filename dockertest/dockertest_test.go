@@ -0,0 +1,145 @@
+package dockertest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/dockerutil/dockergoal"
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+// fakeTB records what a helper does with the test.
+type fakeTB struct {
+	testing.TB
+	name     string
+	failed   bool
+	logs     []string
+	cleanups []func()
+}
+
+func (t *fakeTB) Helper()                                   {}
+func (t *fakeTB) Name() string                              { return t.name }
+func (t *fakeTB) Failed() bool                              { return t.failed }
+func (t *fakeTB) Cleanup(f func())                          { t.cleanups = append(t.cleanups, f) }
+func (t *fakeTB) Log(args ...interface{})                   { t.logs = append(t.logs, fmt.Sprint(args...)) }
+func (t *fakeTB) Logf(format string, args ...interface{})   { t.Log(fmt.Sprintf(format, args...)) }
+func (t *fakeTB) Errorf(format string, args ...interface{}) { t.failed = true; t.Logf(format, args...) }
+func (t *fakeTB) Fatalf(format string, args ...interface{}) { panic(fmt.Sprintf(format, args...)) }
+
+func (t *fakeTB) runCleanups() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+type mockClient struct {
+	dockerclient.Client
+	created []string
+	started []string
+	removed []string
+	logs    []byte
+}
+
+func (m *mockClient) CreateContainer(config *dockerclient.ContainerConfig, name string) (string, error) {
+	m.created = append(m.created, name)
+	return "id-" + name, nil
+}
+
+func (m *mockClient) StartContainer(id string, config *dockerclient.HostConfig) error {
+	m.started = append(m.started, id)
+	return nil
+}
+
+func (m *mockClient) InspectContainer(id string) (*dockerclient.ContainerInfo, error) {
+	if len(m.created) == 0 {
+		return nil, dockerclient.ErrNotFound
+	}
+	ci := &dockerclient.ContainerInfo{
+		Id:     "id-" + m.created[0],
+		Config: &dockerclient.ContainerConfig{},
+	}
+	ci.State.Running = len(m.started) > 0
+	ci.NetworkSettings.Ports = map[string][]dockerclient.PortBinding{
+		"6379/tcp": {{HostIp: "0.0.0.0", HostPort: "32768"}},
+		"9000/tcp": nil,
+	}
+	return ci, nil
+}
+
+func (m *mockClient) RemoveContainer(id string, force, volumes bool) error {
+	if !force || !volumes {
+		return fmt.Errorf("expected force and volumes removal")
+	}
+	m.removed = append(m.removed, id)
+	return nil
+}
+
+func (m *mockClient) ContainerLogs(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(m.logs)), nil
+}
+
+func frame(stream byte, payload string) []byte {
+	header := [8]byte{stream}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header[:], payload...)
+}
+
+func TestStart(t *testing.T) {
+	tb := &fakeTB{name: "TestRedis/sub test"}
+	d := &mockClient{}
+	c := Start(tb, d, &dockerclient.ContainerConfig{Image: "redis"}, nil)
+
+	ensure.True(t, regexp.MustCompile(`^dockertest_TestRedis_sub_test_[0-9a-f]{8}$`).MatchString(c.Name), c.Name)
+	ensure.DeepEqual(t, c.ID, "id-"+c.Name)
+	ensure.DeepEqual(t, len(c.Endpoints), 1)
+	ensure.True(t, strings.HasSuffix(c.Endpoints["6379/tcp"], ":32768"), c.Endpoints)
+	ensure.DeepEqual(t, d.started, []string{c.ID})
+
+	tb.runCleanups()
+	ensure.DeepEqual(t, d.removed, []string{c.ID})
+	ensure.True(t, tb.logs == nil)
+}
+
+func TestStartLogsOnFailure(t *testing.T) {
+	tb := &fakeTB{name: "TestFail"}
+	d := &mockClient{
+		logs: append(frame(1, "ready\npartial"), frame(2, "oops\n")...),
+	}
+	c := Start(tb, d, &dockerclient.ContainerConfig{Image: "redis"}, nil)
+
+	tb.failed = true
+	tb.runCleanups()
+	ensure.DeepEqual(t, tb.logs, []string{
+		c.Name + " stdout: ready",
+		c.Name + " stderr: oops",
+		c.Name + " stdout: partial",
+	})
+	ensure.DeepEqual(t, d.removed, []string{c.ID})
+}
+
+func TestStartUniqueNames(t *testing.T) {
+	tb := &fakeTB{name: "TestTwice"}
+	d := &mockClient{}
+	Start(tb, d, &dockerclient.ContainerConfig{Image: "redis"}, nil)
+	Start(tb, d, &dockerclient.ContainerConfig{Image: "redis"}, nil)
+	ensure.DeepEqual(t, len(d.created), 2)
+	ensure.NotDeepEqual(t, d.created[0], d.created[1])
+}
+
+func TestStartGoal(t *testing.T) {
+	tb := &fakeTB{name: "TestGoal"}
+	d := &mockClient{}
+	c := StartGoal(tb, d, dockergoal.ContainerConfig(&dockerclient.ContainerConfig{Image: "redis"}))
+	ensure.DeepEqual(t, d.created, []string{c.Name})
+	ensure.DeepEqual(t, d.started, []string{c.ID})
+
+	tb.runCleanups()
+	ensure.DeepEqual(t, d.removed, []string{c.ID})
+}
@@ -6,21 +6,54 @@ import (
 )
 
 // ImageID returns the image ID for the given image name. If the imageName is
-// not known, it will also attempt to pull the image as well.
-func ImageID(d dockerclient.Client, imageName string, auth *dockerclient.AuthConfig) (string, error) {
-	id, err := imageIDFromList(d, imageName)
+// not known, it will also attempt to pull the image as well. The name is
+// normalized, so "redis", "redis:latest" and "docker.io/library/redis:latest"
+// all identify the same image. References with a digest, such as
+// "redis@sha256:...", are matched against the image RepoDigests instead of
+// the RepoTags. The options can change when and from where the image is
+// pulled. An *ImageNotFoundError is returned if the image is unknown even
+// after pulling it, and a *PullUnauthorizedError if the registry refused the
+// credentials.
+func ImageID(
+	d dockerclient.Client,
+	imageName string,
+	auth *dockerclient.AuthConfig,
+	options ...PullOption,
+) (string, error) {
+	ref, err := ParseReference(imageName)
 	if err != nil {
 		return "", err
 	}
-	if id != "" {
-		return id, nil
+	o := newPullOptions(options)
+	candidates, err := o.candidates(ref)
+	if err != nil {
+		return "", err
 	}
 
-	if err := d.PullImage(imageName, auth); err != nil {
-		return "", stackerr.Wrap(err)
+	if o.policy != PullAlways {
+		_, id, err := o.localImageID(d, candidates)
+		if err != nil {
+			return "", err
+		}
+		if id != "" {
+			return id, nil
+		}
+
+		id, err = o.loadFromBundle(d, ref)
+		if err != nil {
+			return "", err
+		}
+		if id != "" {
+			return id, nil
+		}
 	}
 
-	id, err = imageIDFromList(d, imageName)
+	pulled, err := o.pullAny(d, ref, candidates, auth)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := o.imageID(d, pulled)
 	if err != nil {
 		return "", err
 	}
@@ -28,22 +61,173 @@ func ImageID(d dockerclient.Client, imageName string, auth *dockerclient.AuthCon
 		return id, nil
 	}
 
-	return "", stackerr.Newf("image named %q could not be identified", imageName)
+	return "", &ImageNotFoundError{Image: imageName}
 }
 
-func imageIDFromList(d dockerclient.Client, imageName string) (string, error) {
+func imageIDFromList(d dockerclient.Client, ref *Reference) (string, error) {
 	images, err := d.ListImages()
 	if err != nil {
 		return "", stackerr.Wrap(err)
 	}
 
 	for _, i := range images {
-		for _, t := range i.RepoTags {
-			if t == imageName {
-				return i.Id, nil
-			}
+		if imageMatchesReference(i, ref) {
+			return i.Id, nil
 		}
 	}
 
 	return "", nil
 }
+
+// imageMatchesReference reports if the image is identified by the reference.
+// If the reference includes a digest only the RepoDigests are considered,
+// since the tag may have moved on to a different image.
+func imageMatchesReference(i *dockerclient.Image, ref *Reference) bool {
+	if ref.Digest != "" {
+		for _, d := range i.RepoDigests {
+			if referenceMatchesDigest(ref, d) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, t := range i.RepoTags {
+		if referenceMatchesTag(ref, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// referenceMatchesTag reports if the RepoTags entry refers to the same image
+// as the reference. Entries which cannot be parsed, such as "<none>:<none>",
+// never match.
+func referenceMatchesTag(ref *Reference, repoTag string) bool {
+	if ref.Tag == "" {
+		return false
+	}
+	tagRef, err := ParseReference(repoTag)
+	if err != nil {
+		return false
+	}
+	return tagRef.Name() == ref.Name() && tagRef.Tag == ref.Tag
+}
+
+// referenceMatchesDigest reports if the RepoDigests entry refers to the same
+// image as the reference.
+func referenceMatchesDigest(ref *Reference, repoDigest string) bool {
+	if ref.Digest == "" {
+		return false
+	}
+	digestRef, err := ParseReference(repoDigest)
+	if err != nil {
+		return false
+	}
+	return digestRef.Name() == ref.Name() && digestRef.Digest == ref.Digest
+}
+
+// pullName returns the name to pull for the reference. A digest takes
+// precedence over the tag, since the daemon pulls by one or the other.
+func pullName(ref *Reference) string {
+	if ref.Digest != "" {
+		return ref.FamiliarName() + "@" + ref.Digest
+	}
+	return ref.Familiar()
+}
+
+// imageHasDigest reports if any of the RepoDigests of the image has the
+// digest, under any name. Digests identify the content, and the image may
+// have been pulled as another name, such as from a mirror.
+func imageHasDigest(i *dockerclient.Image, digest string) bool {
+	for _, d := range i.RepoDigests {
+		digestRef, err := ParseReference(d)
+		if err != nil {
+			continue
+		}
+		if digestRef.Digest == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyImageDigest checks that the image with the given ID carries the
+// digest of the given digest reference. It is useful after a pull to ensure
+// the registry served the content that was asked for. Only the digest is
+// compared, so images pulled from a mirror are verified as well. The daemon
+// doesn't keep the digests of loaded images, so with WithBundleDir an image
+// from a bundle is verified against the ID the bundle recorded instead.
+func VerifyImageDigest(
+	d dockerclient.Client,
+	imageID, imageName string,
+	options ...PullOption,
+) error {
+	ref, err := parseDigestReference(imageName)
+	if err != nil {
+		return err
+	}
+
+	_, bi, err := newPullOptions(options).findBundle(ref)
+	if err != nil {
+		return err
+	}
+	if bi != nil && bi.ID == imageID {
+		return nil
+	}
+
+	i, err := findImage(d, imageID)
+	if err != nil {
+		return err
+	}
+	if !imageHasDigest(i, ref.Digest) {
+		return stackerr.Newf(
+			"image %q has digests %v but %q was requested",
+			imageID,
+			i.RepoDigests,
+			imageName,
+		)
+	}
+	return nil
+}
+
+// ImageHasDigest reports if the image with the given ID carries the digest of
+// the given digest reference. Like VerifyImageDigest it only compares the
+// digest.
+func ImageHasDigest(d dockerclient.Client, imageID, imageName string) (bool, error) {
+	ref, err := parseDigestReference(imageName)
+	if err != nil {
+		return false, err
+	}
+
+	i, err := findImage(d, imageID)
+	if err != nil {
+		return false, err
+	}
+	return imageHasDigest(i, ref.Digest), nil
+}
+
+func parseDigestReference(imageName string) (*Reference, error) {
+	ref, err := ParseReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest == "" {
+		return nil, stackerr.Newf("image %q is not pinned to a digest", imageName)
+	}
+	return ref, nil
+}
+
+func findImage(d dockerclient.Client, imageID string) (*dockerclient.Image, error) {
+	images, err := d.ListImages()
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	for _, i := range images {
+		if i.Id == imageID {
+			return i, nil
+		}
+	}
+
+	return nil, stackerr.Newf("image %q could not be found", imageID)
+}
@@ -0,0 +1,173 @@
+package dockerutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+var (
+	// ErrNameConflict matches a *NameConflictError using errors.Is.
+	ErrNameConflict = errors.New("dockerutil: container name already in use")
+
+	// ErrImageNotFound matches an *ImageNotFoundError using errors.Is.
+	ErrImageNotFound = errors.New("dockerutil: image not found")
+
+	// ErrPullUnauthorized matches a *PullUnauthorizedError using errors.Is.
+	ErrPullUnauthorized = errors.New("dockerutil: pull unauthorized")
+)
+
+// NameConflictError is returned when a container cannot be created because
+// another container already has the name.
+type NameConflictError struct {
+	Name string
+
+	// ExistingID is the ID of the container holding the name. It is empty if
+	// the container could not be inspected.
+	ExistingID string
+
+	// Err is the error returned by the daemon.
+	Err error
+}
+
+func (e *NameConflictError) Error() string {
+	if e.ExistingID == "" {
+		return fmt.Sprintf("container name %q is already in use", e.Name)
+	}
+	return fmt.Sprintf("container name %q is already in use by container %q", e.Name, e.ExistingID)
+}
+
+// Is reports if the target is ErrNameConflict.
+func (e *NameConflictError) Is(target error) bool { return target == ErrNameConflict }
+
+// Unwrap returns the error returned by the daemon.
+func (e *NameConflictError) Unwrap() error { return e.Err }
+
+// ImageNotFoundError is returned when the image of a container cannot be
+// found, even after pulling it, or when the registry does not know it.
+type ImageNotFoundError struct {
+	Image string
+
+	// Err is the underlying error, usually dockerclient.ErrNotFound.
+	Err error
+}
+
+func (e *ImageNotFoundError) Error() string {
+	return fmt.Sprintf("image %q not found", e.Image)
+}
+
+// Is reports if the target is ErrImageNotFound.
+func (e *ImageNotFoundError) Is(target error) bool { return target == ErrImageNotFound }
+
+// Unwrap returns the underlying error.
+func (e *ImageNotFoundError) Unwrap() error { return e.Err }
+
+// PullUnauthorizedError is returned when the registry refuses a pull because
+// of missing or invalid credentials.
+type PullUnauthorizedError struct {
+	Image string
+
+	// Err is the error returned by the daemon.
+	Err error
+}
+
+func (e *PullUnauthorizedError) Error() string {
+	return fmt.Sprintf("pulling %q is unauthorized: %s", e.Image, e.Err)
+}
+
+// Is reports if the target is ErrPullUnauthorized.
+func (e *PullUnauthorizedError) Is(target error) bool { return target == ErrPullUnauthorized }
+
+// Unwrap returns the error returned by the daemon.
+func (e *PullUnauthorizedError) Unwrap() error { return e.Err }
+
+// WithRemoveConflicting makes CreateWithPull remove a stopped container which
+// holds the name of the new container, and retry creating it. A running
+// container is never removed, a *NameConflictError is returned instead.
+func WithRemoveConflicting() PullOption {
+	return func(o *pullOptions) {
+		o.removeConflicting = true
+	}
+}
+
+// createContainer creates the container, turning a name conflict into a
+// *NameConflictError unless the conflicting container may be removed. Other
+// errors are returned as is.
+func (o *pullOptions) createContainer(
+	d dockerclient.Client,
+	c *dockerclient.ContainerConfig,
+	name string,
+) (string, error) {
+	id, err := d.CreateContainer(c, name)
+	if err == nil || errorStatusCode(err) != http.StatusConflict {
+		return id, err
+	}
+
+	conflict := &NameConflictError{Name: name, Err: err}
+	ci, err := d.InspectContainer(name)
+	if err != nil {
+		return "", conflict
+	}
+	conflict.ExistingID = ci.Id
+	if !o.removeConflicting || ci.State.Running {
+		return "", conflict
+	}
+
+	if err := d.RemoveContainer(ci.Id, false, false); err != nil {
+		return "", stackerr.Wrap(err)
+	}
+	return d.CreateContainer(c, name)
+}
+
+// pullError turns the error of a failed pull into an *ImageNotFoundError or a
+// *PullUnauthorizedError when possible.
+func pullError(imageName string, err error) error {
+	switch {
+	case stackerr.HasUnderlying(err, stackerr.Equals(dockerclient.ErrNotFound)):
+		return &ImageNotFoundError{Image: imageName, Err: dockerclient.ErrNotFound}
+	case isUnauthorized(err):
+		return &PullUnauthorizedError{Image: imageName, Err: err}
+	}
+	return err
+}
+
+// isUnauthorized reports if the error is a registry authentication failure.
+// Depending on the daemon version these are either an unsuccessful status or
+// an error message in the progress stream.
+func isUnauthorized(err error) bool {
+	switch errorStatusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"unauthorized", "authentication required", "access denied", "denied:"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorStatusCode returns the HTTP status code carried by an error from the
+// daemon, or 0 if there is none.
+func errorStatusCode(err error) int {
+	var code int
+	stackerr.HasUnderlying(err, func(err error) bool {
+		switch e := err.(type) {
+		case dockerclient.Error:
+			code = e.StatusCode
+		case *dockerclient.Error:
+			code = e.StatusCode
+		case *apiError:
+			code = e.statusCode
+		default:
+			return false
+		}
+		return true
+	})
+	return code
+}
@@ -0,0 +1,129 @@
+package dockerutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+var errTestConflict = dockerclient.Error{StatusCode: 409, Status: "409 Conflict"}
+
+func TestCreateWithPullNameConflict(t *testing.T) {
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			return "", errTestConflict
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: "old"}, nil
+		},
+	}
+	c := &dockerclient.ContainerConfig{Image: "redis"}
+	_, err := CreateWithPull(client, c, "db", nil)
+	ensure.True(t, errors.Is(err, ErrNameConflict))
+
+	var conflict *NameConflictError
+	ensure.True(t, errors.As(err, &conflict))
+	ensure.DeepEqual(t, conflict.Name, "db")
+	ensure.DeepEqual(t, conflict.ExistingID, "old")
+	ensure.DeepEqual(t, err.Error(), `container name "db" is already in use by container "old"`)
+}
+
+func TestCreateWithPullRemoveConflicting(t *testing.T) {
+	var removed []string
+	var creates int
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			creates++
+			if creates == 1 {
+				return "", errTestConflict
+			}
+			return "new", nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: "old"}, nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			removed = append(removed, id)
+			return nil
+		},
+	}
+	c := &dockerclient.ContainerConfig{Image: "redis"}
+	id, err := CreateWithPull(client, c, "db", nil, WithRemoveConflicting())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, id, "new")
+	ensure.DeepEqual(t, removed, []string{"old"})
+}
+
+func TestCreateWithPullRemoveConflictingRunning(t *testing.T) {
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			return "", errTestConflict
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: "old"}
+			ci.State.Running = true
+			return ci, nil
+		},
+	}
+	c := &dockerclient.ContainerConfig{Image: "redis"}
+	_, err := CreateWithPull(client, c, "db", nil, WithRemoveConflicting())
+	ensure.True(t, errors.Is(err, ErrNameConflict))
+}
+
+func TestCreateWithPullImageNotFound(t *testing.T) {
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			return "", dockerclient.ErrNotFound
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			return nil
+		},
+	}
+	c := &dockerclient.ContainerConfig{Image: "redis"}
+	_, err := CreateWithPull(client, c, "db", nil)
+	ensure.True(t, errors.Is(err, ErrImageNotFound))
+	ensure.True(t, errors.Is(err, dockerclient.ErrNotFound))
+	ensure.False(t, errors.Is(err, ErrPullUnauthorized))
+
+	var notFound *ImageNotFoundError
+	ensure.True(t, errors.As(err, &notFound))
+	ensure.DeepEqual(t, notFound.Image, "redis")
+}
+
+func TestCreateWithPullUnauthorized(t *testing.T) {
+	cases := []error{
+		dockerclient.Error{StatusCode: 401, Status: "401 Unauthorized"},
+		stackerr.Wrap(&apiError{statusCode: 403, message: "forbidden"}),
+		stackerr.New("unauthorized: authentication required"),
+	}
+	for _, pullErr := range cases {
+		client := &mockClient{
+			createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+				return "", dockerclient.ErrNotFound
+			},
+			pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+				return pullErr
+			},
+		}
+		c := &dockerclient.ContainerConfig{Image: "private/app"}
+		_, err := CreateWithPull(client, c, "app", nil)
+		ensure.True(t, errors.Is(err, ErrPullUnauthorized), pullErr)
+
+		var unauthorized *PullUnauthorizedError
+		ensure.True(t, errors.As(err, &unauthorized))
+		ensure.DeepEqual(t, unauthorized.Image, "private/app:latest")
+	}
+}
+
+func TestPullErrorNotFound(t *testing.T) {
+	err := pullError("redis", stackerr.Wrap(dockerclient.ErrNotFound))
+	ensure.True(t, errors.Is(err, ErrImageNotFound))
+}
+
+func TestPullErrorOther(t *testing.T) {
+	givenErr := errors.New("connection refused")
+	ensure.True(t, pullError("redis", givenErr) == givenErr)
+}
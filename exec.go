@@ -0,0 +1,149 @@
+package dockerutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// execPollInterval is how often Exec checks if the exec instance finished
+// after its output ended.
+var execPollInterval = 50 * time.Millisecond
+
+// ExecOptions configures Exec.
+type ExecOptions struct {
+	// Stdin is fed to the command if not nil. Its end is signaled to the
+	// command by closing its standard input. Whatever the command doesn't
+	// read before exiting is discarded.
+	Stdin io.Reader
+
+	// Stdout and Stderr receive the output of the command as it is produced.
+	// If they are nil the output is captured in the ExecResult instead.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Tty allocates a TTY for the command, in which case all the output goes
+	// to Stdout.
+	Tty bool
+}
+
+// ExecResult is the outcome of Exec.
+type ExecResult struct {
+	// ExitCode is the exit code of the command.
+	ExitCode int
+
+	// Stdout and Stderr hold the captured output of the command, if it wasn't
+	// written to the ExecOptions writers.
+	Stdout []byte
+	Stderr []byte
+}
+
+// Exec runs the command in the running container and waits for it to finish.
+// A non zero exit code is not an error, which makes Exec useful as a readiness
+// probe as well as for seeding data.
+func Exec(
+	d *dockerclient.DockerClient,
+	container string,
+	cmd []string,
+	o ExecOptions,
+) (*ExecResult, error) {
+	config, err := json.Marshal(&dockerclient.ExecConfig{
+		AttachStdin:  o.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          o.Tty,
+		Cmd:          cmd,
+		Container:    container,
+	})
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	res, err := apiRequest(d, "POST", "/containers/"+container+"/exec", nil, bytes.NewReader(config), header)
+	if err != nil {
+		return nil, err
+	}
+	var created struct{ Id string }
+	err = json.NewDecoder(res.Body).Decode(&created)
+	res.Body.Close()
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	start, err := json.Marshal(map[string]bool{"Detach": false, "Tty": o.Tty})
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	conn, output, err := hijack(d, "POST", "/exec/"+created.Id+"/start", start)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if o.Stdin != nil {
+		go func() {
+			// the command may exit without reading all of its input, so
+			// failing to write it isn't an error
+			io.Copy(conn, o.Stdin)
+			closeWrite(conn)
+		}()
+	}
+
+	var result ExecResult
+	var stdoutBuf, stderrBuf bytes.Buffer
+	stdout, stderr := o.Stdout, o.Stderr
+	if stdout == nil {
+		stdout = &stdoutBuf
+	}
+	if stderr == nil {
+		stderr = &stderrBuf
+	}
+	if o.Tty {
+		_, err = io.Copy(stdout, output)
+		err = stackerr.Wrap(err)
+	} else {
+		err = DemuxLogs(output, stdout, stderr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	result.ExitCode, err = execExitCode(d, created.Id)
+	if err != nil {
+		return nil, err
+	}
+	result.Stdout = stdoutBuf.Bytes()
+	result.Stderr = stderrBuf.Bytes()
+	return &result, nil
+}
+
+// execExitCode waits for the exec instance to finish and returns its exit
+// code.
+func execExitCode(d *dockerclient.DockerClient, id string) (int, error) {
+	for {
+		res, err := apiRequest(d, "GET", "/exec/"+id+"/json", nil, nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		var inspect struct {
+			Running  bool
+			ExitCode int
+		}
+		err = json.NewDecoder(res.Body).Decode(&inspect)
+		res.Body.Close()
+		if err != nil {
+			return 0, stackerr.Wrap(err)
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		time.Sleep(execPollInterval)
+	}
+}
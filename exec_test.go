@@ -0,0 +1,103 @@
+package dockerutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func execServer(t *testing.T) *httptest.Server {
+	var inspects int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/db/exec":
+			var config dockerclient.ExecConfig
+			ensure.Nil(t, json.NewDecoder(r.Body).Decode(&config))
+			ensure.DeepEqual(t, config.Cmd, []string{"psql", "-f", "-"})
+			ensure.True(t, config.AttachStdout)
+			ensure.True(t, config.AttachStderr)
+			fmt.Fprintf(w, `{"Id": "e1", "Stdin": %t}`, config.AttachStdin)
+		case "/containers/missing/exec":
+			http.Error(w, "no such container", http.StatusNotFound)
+		case "/exec/e1/start":
+			ensure.DeepEqual(t, r.Header.Get("Upgrade"), "tcp")
+			var start map[string]bool
+			ensure.Nil(t, json.NewDecoder(r.Body).Decode(&start))
+			ensure.DeepEqual(t, start, map[string]bool{"Detach": false, "Tty": false})
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			ensure.Nil(t, err)
+			defer conn.Close()
+			io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\n"+
+				"Content-Type: application/vnd.docker.raw-stream\r\n"+
+				"Connection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+
+			// echo the input until it's closed
+			input, err := ioutil.ReadAll(buf)
+			ensure.Nil(t, err)
+			conn.Write(muxLogs(
+				streamStdout, "read "+strings.ToUpper(string(input)),
+				streamStderr, "NOTICE: done\n",
+			))
+		case "/exec/e1/json":
+			inspects++
+			fmt.Fprintf(w, `{"Running": %t, "ExitCode": 2}`, inspects == 1)
+		default:
+			t.Fatalf("unexpected request %s", r.URL)
+		}
+	}))
+}
+
+func TestExec(t *testing.T) {
+	server := execServer(t)
+	defer server.Close()
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+
+	result, err := Exec(d, "db", []string{"psql", "-f", "-"}, ExecOptions{
+		Stdin: strings.NewReader("insert\n"),
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result, &ExecResult{
+		ExitCode: 2,
+		Stdout:   []byte("read INSERT\n"),
+		Stderr:   []byte("NOTICE: done\n"),
+	})
+}
+
+func TestExecStreamingOutput(t *testing.T) {
+	server := execServer(t)
+	defer server.Close()
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+
+	var stdout, stderr bytes.Buffer
+	result, err := Exec(d, "db", []string{"psql", "-f", "-"}, ExecOptions{
+		Stdin:  strings.NewReader("x\n"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, stdout.String(), "read X\n")
+	ensure.DeepEqual(t, stderr.String(), "NOTICE: done\n")
+	ensure.True(t, result.Stdout == nil)
+	ensure.True(t, result.Stderr == nil)
+}
+
+func TestExecMissingContainer(t *testing.T) {
+	server := execServer(t)
+	defer server.Close()
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+
+	_, err = Exec(d, "missing", []string{"true"}, ExecOptions{})
+	ensure.True(t, err == dockerclient.ErrNotFound)
+}
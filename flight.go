@@ -0,0 +1,84 @@
+package dockerutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/samalba/dockerclient"
+)
+
+// inFlightPulls shares pulls of the same image on the same client with the
+// same credentials between concurrent callers.
+var inFlightPulls pullGroup
+
+type pullKey struct {
+	client dockerclient.Client
+	image  string
+	auth   [sha256.Size]byte
+}
+
+type pullCall struct {
+	done chan struct{}
+	err  error
+}
+
+// pullGroup deduplicates concurrent pulls, in the spirit of singleflight.
+type pullGroup struct {
+	mu    sync.Mutex
+	calls map[pullKey]*pullCall
+}
+
+// do runs pull unless a pull for the same client, image and credentials is
+// already in flight, in which case it waits for that one and returns its
+// result. Callers with different credentials don't share pulls, since one
+// may be authorized where the other isn't. If ctx
+// is done first the caller stops waiting and gets the context error, while
+// the pull itself carries on for the other callers.
+func (g *pullGroup) do(
+	ctx context.Context,
+	d dockerclient.Client,
+	image string,
+	auth *dockerclient.AuthConfig,
+	pull func() error,
+) error {
+	// clients which can't be used as a map key are never deduplicated
+	if !reflect.TypeOf(d).Comparable() {
+		return pull()
+	}
+
+	key := pullKey{client: d, image: image}
+	if auth != nil {
+		data, err := json.Marshal(auth)
+		if err != nil {
+			return pull()
+		}
+		key.auth = sha256.Sum256(data)
+	}
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[pullKey]*pullCall{}
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &pullCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go func() {
+			call.err = pull()
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,125 @@
+package dockerutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// joinContext reports on joined whenever a caller starts waiting on it, which
+// pullGroup.do does once it joined a pull.
+type joinContext struct {
+	context.Context
+	joined chan struct{}
+}
+
+func newJoinContext(ctx context.Context) joinContext {
+	return joinContext{Context: ctx, joined: make(chan struct{}, 16)}
+}
+
+func (c joinContext) Done() <-chan struct{} {
+	c.joined <- struct{}{}
+	return c.Context.Done()
+}
+
+// waitForJoins blocks until n callers joined pulls using the context.
+func (c joinContext) waitForJoins(n int) {
+	for i := 0; i < n; i++ {
+		<-c.joined
+	}
+}
+
+func TestPullDeduplicated(t *testing.T) {
+	givenErr := errors.New("")
+	release := make(chan struct{})
+	var pulls int
+	client := &mockClient{
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			pulls++
+			ensure.DeepEqual(t, name, "redis:latest")
+			<-release
+			return givenErr
+		},
+	}
+
+	ctx := newJoinContext(context.Background())
+	names := []string{"redis", "redis:latest", "library/redis", "docker.io/redis"}
+	errs := make(chan error, len(names))
+	for _, name := range names {
+		ref, err := ParseReference(name)
+		ensure.Nil(t, err)
+		go func() {
+			o := newPullOptions(nil)
+			o.ctx = ctx
+			errs <- o.pull(client, ref, nil)
+		}()
+	}
+
+	ctx.waitForJoins(len(names))
+	close(release)
+
+	for range names {
+		ensure.True(t, stackerr.HasUnderlying(<-errs, stackerr.Equals(givenErr)))
+	}
+	ensure.DeepEqual(t, pulls, 1)
+}
+
+func TestPullGroupSeparatesAuth(t *testing.T) {
+	var g pullGroup
+	client := &mockClient{}
+	release := make(chan struct{})
+	ctx := newJoinContext(context.Background())
+
+	unauthorized := errors.New("unauthorized")
+	results := make(chan error)
+	go func() {
+		results <- g.do(ctx, client, "x", nil, func() error {
+			<-release
+			return unauthorized
+		})
+	}()
+	ctx.waitForJoins(1)
+
+	auth := &dockerclient.AuthConfig{Username: "u", Password: "p"}
+	ensure.Nil(t, g.do(ctx, client, "x", auth, func() error {
+		return nil
+	}))
+
+	close(release)
+	ensure.DeepEqual(t, <-results, unauthorized)
+}
+
+func TestPullGroupCancelIndependent(t *testing.T) {
+	var g pullGroup
+	client := &mockClient{}
+	release := make(chan struct{})
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	ctx := newJoinContext(cancelCtx)
+	canceled := make(chan error)
+	go func() {
+		canceled <- g.do(ctx, client, "x", nil, func() error {
+			<-release
+			return nil
+		})
+	}()
+	ctx.waitForJoins(1)
+
+	other := newJoinContext(context.Background())
+	waiting := make(chan error)
+	go func() {
+		waiting <- g.do(other, client, "x", nil, func() error {
+			panic("not reached")
+		})
+	}()
+	other.waitForJoins(1)
+
+	cancel()
+	ensure.DeepEqual(t, <-canceled, context.Canceled)
+	close(release)
+	ensure.Nil(t, <-waiting)
+}
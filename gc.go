@@ -0,0 +1,223 @@
+package dockerutil
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// GCOptions configures GCImages.
+type GCOptions struct {
+	// KeepPerRepository is the number of newest images to keep in each
+	// repository, even if no container uses them.
+	KeepPerRepository int
+
+	// Protect holds patterns for images which are never removed. They are
+	// matched using path.Match against both the short and the fully qualified
+	// form of each tag, for example "redis:*" or "docker.io/library/*:latest".
+	Protect []string
+
+	// DryRun only reports what would be removed.
+	DryRun bool
+}
+
+// GCResult reports the outcome of GCImages.
+type GCResult struct {
+	// Removed are the images which were removed, or would be with DryRun.
+	// Images which were gone already or failed to be removed are not
+	// included.
+	Removed []*dockerclient.Image
+
+	// ReclaimedBytes is the sum of the sizes of the removed images. It is an
+	// estimate, since layers may be shared with images which are kept.
+	ReclaimedBytes int64
+
+	// Failed are the images which could not be removed, for example because
+	// a container started using them in the meantime.
+	Failed []*GCError
+}
+
+// GCError is the error removing an image during GCImages.
+type GCError struct {
+	Image *dockerclient.Image
+	Err   error
+}
+
+func (e *GCError) Error() string {
+	return fmt.Sprintf("removing image %s: %s", e.Image.Id, e.Err)
+}
+
+// GCImages removes images which are not used by any container, running or
+// stopped, except the newest ones in each repository and the protected ones.
+// Images with child images are never removed, so removing a chain of unused
+// images may take more than one run. Failing to remove an image does not stop
+// the collection, the failures are reported in the result instead.
+func GCImages(d dockerclient.Client, o GCOptions) (*GCResult, error) {
+	for _, p := range o.Protect {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, stackerr.Newf("invalid protect pattern %q", p)
+		}
+	}
+
+	images, err := d.ListImages()
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	used, err := usedImageIDs(d)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := map[string]bool{}
+	for id := range used {
+		keep[id] = true
+	}
+	for _, i := range images {
+		if i.ParentId != "" {
+			keep[i.ParentId] = true
+		}
+		if imageProtected(i, o.Protect) {
+			keep[i.Id] = true
+		}
+	}
+	for _, id := range newestPerRepository(images, o.KeepPerRepository) {
+		keep[id] = true
+	}
+
+	var unused []*dockerclient.Image
+	for _, i := range images {
+		if !keep[i.Id] {
+			unused = append(unused, i)
+		}
+	}
+
+	// remove newer images first, they may be children of older ones
+	sort.Stable(imagesByNewest(unused))
+	var result GCResult
+	for _, i := range unused {
+		if !o.DryRun {
+			removed, err := removeImage(d, i)
+			if err != nil {
+				result.Failed = append(result.Failed, &GCError{Image: i, Err: err})
+				continue
+			}
+			if !removed {
+				continue
+			}
+		}
+		result.Removed = append(result.Removed, i)
+		result.ReclaimedBytes += i.Size
+	}
+	return &result, nil
+}
+
+// usedImageIDs returns the IDs of the images used by all containers.
+func usedImageIDs(d dockerclient.Client) (map[string]bool, error) {
+	containers, err := d.ListContainers(true, false, "")
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	used := map[string]bool{}
+	for _, c := range containers {
+		// the list has the image name the container was created with, which
+		// may since point to a different image, so we need the ID
+		ci, err := d.InspectContainer(c.Id)
+		if err != nil {
+			// the container was removed in the meantime
+			if err == dockerclient.ErrNotFound {
+				continue
+			}
+			return nil, stackerr.Wrap(err)
+		}
+		used[ci.Image] = true
+	}
+	return used, nil
+}
+
+func imageProtected(i *dockerclient.Image, patterns []string) bool {
+	for _, t := range i.RepoTags {
+		ref, err := ParseReference(t)
+		if err != nil {
+			continue
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, ref.Familiar()); ok {
+				return true
+			}
+			if ok, _ := path.Match(p, ref.String()); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newestPerRepository returns the IDs of the n newest images in each
+// repository.
+func newestPerRepository(images []*dockerclient.Image, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	repos := map[string][]*dockerclient.Image{}
+	for _, i := range images {
+		seen := map[string]bool{}
+		for _, t := range i.RepoTags {
+			ref, err := ParseReference(t)
+			if err != nil || seen[ref.Name()] {
+				continue
+			}
+			seen[ref.Name()] = true
+			repos[ref.Name()] = append(repos[ref.Name()], i)
+		}
+	}
+
+	var ids []string
+	for _, repoImages := range repos {
+		sort.Stable(imagesByNewest(repoImages))
+		if len(repoImages) > n {
+			repoImages = repoImages[:n]
+		}
+		for _, i := range repoImages {
+			ids = append(ids, i.Id)
+		}
+	}
+	return ids
+}
+
+// removeImage removes all the tags of the image, which removes the image
+// along with the last one. Untagged images are removed by ID. It reports
+// false if the image was gone already.
+func removeImage(d dockerclient.Client, i *dockerclient.Image) (bool, error) {
+	var tags []string
+	for _, t := range i.RepoTags {
+		if _, err := ParseReference(t); err == nil {
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) == 0 {
+		tags = []string{i.Id}
+	}
+	removed := false
+	for _, t := range tags {
+		_, err := d.RemoveImage(t)
+		if err == dockerclient.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return removed, stackerr.Wrap(err)
+		}
+		removed = true
+	}
+	return removed, nil
+}
+
+type imagesByNewest []*dockerclient.Image
+
+func (s imagesByNewest) Len() int           { return len(s) }
+func (s imagesByNewest) Less(i, j int) bool { return s[i].Created > s[j].Created }
+func (s imagesByNewest) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
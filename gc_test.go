@@ -0,0 +1,205 @@
+package dockerutil
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+var gcTestImages = []*dockerclient.Image{
+	{Id: "redis1", Created: 1, Size: 10, RepoTags: []string{"redis:1"}},
+	{Id: "redis2", Created: 2, Size: 20, RepoTags: []string{"redis:2"}},
+	{Id: "redis3", Created: 3, Size: 30, RepoTags: []string{"redis:3"}},
+	{Id: "app1", Created: 1, Size: 100, RepoTags: []string{"app:1", "app:stable"}},
+	{Id: "app2", Created: 2, Size: 200, RepoTags: []string{"app:2"}},
+	{Id: "base", Created: 0, Size: 1000, RepoTags: []string{"<none>:<none>"}},
+	{Id: "child", Created: 4, Size: 1, ParentId: "base", RepoTags: []string{"<none>:<none>"}},
+	{Id: "tools", Created: 0, Size: 5, RepoTags: []string{"internal.example.com/tools:1"}},
+}
+
+func removedIDs(r *GCResult) []string {
+	var ids []string
+	for _, i := range r.Removed {
+		ids = append(ids, i.Id)
+	}
+	return ids
+}
+
+func TestGCImagesKeepNewest(t *testing.T) {
+	var removed []string
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return gcTestImages, nil
+		},
+		listContainers: func(all, size bool, filters string) ([]dockerclient.Container, error) {
+			ensure.True(t, all)
+			return []dockerclient.Container{{Id: "c1"}, {Id: "c2"}}, nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			if id == "c1" {
+				return &dockerclient.ContainerInfo{Image: "redis1"}, nil
+			}
+			return nil, dockerclient.ErrNotFound
+		},
+		removeImage: func(name string) ([]*dockerclient.ImageDelete, error) {
+			removed = append(removed, name)
+			return nil, nil
+		},
+	}
+	r, err := GCImages(client, GCOptions{KeepPerRepository: 1})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, removedIDs(r), []string{"child", "redis2", "app1"})
+	ensure.DeepEqual(t, r.ReclaimedBytes, int64(121))
+	ensure.DeepEqual(t, removed, []string{"child", "redis:2", "app:1", "app:stable"})
+}
+
+func TestGCImagesProtect(t *testing.T) {
+	var removed []string
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return gcTestImages, nil
+		},
+		listContainers: func(all, size bool, filters string) ([]dockerclient.Container, error) {
+			ensure.True(t, all)
+			return []dockerclient.Container{{Id: "c1"}, {Id: "c2"}}, nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			if id == "c1" {
+				return &dockerclient.ContainerInfo{Image: "redis1"}, nil
+			}
+			return nil, dockerclient.ErrNotFound
+		},
+		removeImage: func(name string) ([]*dockerclient.ImageDelete, error) {
+			removed = append(removed, name)
+			return nil, nil
+		},
+	}
+	r, err := GCImages(client, GCOptions{
+		Protect: []string{"app:stable", "internal.example.com/*"},
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, removedIDs(r), []string{"child", "redis3", "redis2", "app2"})
+	ensure.DeepEqual(t, r.ReclaimedBytes, int64(251))
+	ensure.DeepEqual(t, removed, []string{"child", "redis:3", "redis:2", "app:2"})
+}
+
+func TestGCImagesProtectFullyQualified(t *testing.T) {
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return gcTestImages, nil
+		},
+		listContainers: func(all, size bool, filters string) ([]dockerclient.Container, error) {
+			ensure.True(t, all)
+			return []dockerclient.Container{{Id: "c1"}, {Id: "c2"}}, nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			if id == "c1" {
+				return &dockerclient.ContainerInfo{Image: "redis1"}, nil
+			}
+			return nil, dockerclient.ErrNotFound
+		},
+		removeImage: func(name string) ([]*dockerclient.ImageDelete, error) {
+			panic("not reached")
+		},
+	}
+	r, err := GCImages(client, GCOptions{
+		Protect: []string{"docker.io/library/redis:*"},
+		DryRun:  true,
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, removedIDs(r), []string{"child", "app2", "app1", "tools"})
+}
+
+func TestGCImagesDryRun(t *testing.T) {
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return gcTestImages, nil
+		},
+		listContainers: func(all, size bool, filters string) ([]dockerclient.Container, error) {
+			ensure.True(t, all)
+			return []dockerclient.Container{{Id: "c1"}, {Id: "c2"}}, nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			if id == "c1" {
+				return &dockerclient.ContainerInfo{Image: "redis1"}, nil
+			}
+			return nil, dockerclient.ErrNotFound
+		},
+		removeImage: func(name string) ([]*dockerclient.ImageDelete, error) {
+			panic("not reached")
+		},
+	}
+	r, err := GCImages(client, GCOptions{KeepPerRepository: 1, DryRun: true})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, r.ReclaimedBytes, int64(121))
+	ensure.DeepEqual(t, len(r.Removed), 3)
+}
+
+func TestGCImagesInvalidPattern(t *testing.T) {
+	_, err := GCImages(&mockClient{}, GCOptions{Protect: []string{"["}})
+	ensure.Err(t, err, regexp.MustCompile(`invalid protect pattern "\["`))
+}
+
+func TestGCImagesIgnoresRemovedImages(t *testing.T) {
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return gcTestImages, nil
+		},
+		listContainers: func(all, size bool, filters string) ([]dockerclient.Container, error) {
+			ensure.True(t, all)
+			return []dockerclient.Container{{Id: "c1"}, {Id: "c2"}}, nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			if id == "c1" {
+				return &dockerclient.ContainerInfo{Image: "redis1"}, nil
+			}
+			return nil, dockerclient.ErrNotFound
+		},
+		removeImage: func(name string) ([]*dockerclient.ImageDelete, error) {
+			return nil, dockerclient.ErrNotFound
+		},
+	}
+	r, err := GCImages(client, GCOptions{KeepPerRepository: 1})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, r, &GCResult{})
+}
+
+func TestGCImagesContinuesAfterErrors(t *testing.T) {
+	var removed []string
+	inUse := errors.New("image is being used by running container")
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return gcTestImages, nil
+		},
+		listContainers: func(all, size bool, filters string) ([]dockerclient.Container, error) {
+			ensure.True(t, all)
+			return []dockerclient.Container{{Id: "c1"}, {Id: "c2"}}, nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			if id == "c1" {
+				return &dockerclient.ContainerInfo{Image: "redis1"}, nil
+			}
+			return nil, dockerclient.ErrNotFound
+		},
+		removeImage: func(name string) ([]*dockerclient.ImageDelete, error) {
+			if name == "redis:2" {
+				return nil, inUse
+			}
+			removed = append(removed, name)
+			return nil, nil
+		},
+	}
+	r, err := GCImages(client, GCOptions{KeepPerRepository: 1})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, removedIDs(r), []string{"child", "app1"})
+	ensure.DeepEqual(t, r.ReclaimedBytes, int64(101))
+	ensure.DeepEqual(t, removed, []string{"child", "app:1", "app:stable"})
+	ensure.DeepEqual(t, len(r.Failed), 1)
+	ensure.DeepEqual(t, r.Failed[0].Image.Id, "redis2")
+	ensure.True(t, stackerr.HasUnderlying(r.Failed[0].Err, stackerr.Equals(inUse)))
+	ensure.Err(t, r.Failed[0], regexp.MustCompile("removing image redis2: image is being used"))
+}
@@ -0,0 +1,137 @@
+package dockerutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// imageEvents are the event statuses which change the image list.
+var imageEvents = map[string]bool{
+	"pull":   true,
+	"tag":    true,
+	"untag":  true,
+	"delete": true,
+	"load":   true,
+	"import": true,
+}
+
+// ImageIndex caches the image list of a daemon, indexed by tag and digest, so
+// identifying an image does not require listing all images every time. The
+// cache is invalidated by pulls done through ImageID and CreateWithPull, when
+// the TTL expires, and on image events if Watch was called.
+type ImageIndex struct {
+	docker dockerclient.Client
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	loaded   bool
+	byTag    map[string]*dockerclient.Image
+	byDigest map[string]*dockerclient.Image
+	byID     map[string]*dockerclient.Image
+}
+
+// NewImageIndex creates an index for the images of the daemon. A zero TTL
+// means the index is only refreshed when it is invalidated.
+func NewImageIndex(d dockerclient.Client, ttl time.Duration) *ImageIndex {
+	return &ImageIndex{docker: d, ttl: ttl}
+}
+
+// WithImageIndex makes ImageID and CreateWithPull look up images in the index
+// instead of listing all images.
+func WithImageIndex(x *ImageIndex) PullOption {
+	return func(o *pullOptions) {
+		o.index = x
+	}
+}
+
+// Watch invalidates the index whenever the daemon reports an image being
+// pulled, tagged, untagged, deleted, loaded or imported. Errors from the event
+// stream are sent on ec. The monitor is stopped by StopAllMonitorEvents on
+// the client.
+func (x *ImageIndex) Watch(ec chan error) {
+	x.docker.StartMonitorEvents(x.handleEvent, ec)
+}
+
+func (x *ImageIndex) handleEvent(e *dockerclient.Event, ec chan error, args ...interface{}) {
+	if imageEvents[e.Status] {
+		x.Invalidate()
+	}
+}
+
+// Invalidate drops the cached image list. The next lookup lists the images
+// again.
+func (x *ImageIndex) Invalidate() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.loaded = false
+}
+
+// ImageID returns the ID of the image identified by the reference, or an
+// empty string if there is no such image.
+func (x *ImageIndex) ImageID(ref *Reference) (string, error) {
+	i, err := x.lookup(ref)
+	if err != nil || i == nil {
+		return "", err
+	}
+	return i.Id, nil
+}
+
+// Image returns the image with the given ID, or nil if there is no such
+// image.
+func (x *ImageIndex) Image(id string) (*dockerclient.Image, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.load(); err != nil {
+		return nil, err
+	}
+	return x.byID[id], nil
+}
+
+func (x *ImageIndex) lookup(ref *Reference) (*dockerclient.Image, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.load(); err != nil {
+		return nil, err
+	}
+	if ref.Digest != "" {
+		return x.byDigest[ref.Name()+"@"+ref.Digest], nil
+	}
+	return x.byTag[ref.Name()+":"+ref.Tag], nil
+}
+
+// load lists the images if the index isn't loaded or expired. It must be
+// called with the lock held.
+func (x *ImageIndex) load() error {
+	if x.loaded && (x.ttl == 0 || time.Since(x.loadedAt) < x.ttl) {
+		return nil
+	}
+
+	images, err := x.docker.ListImages()
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+
+	x.byTag = map[string]*dockerclient.Image{}
+	x.byDigest = map[string]*dockerclient.Image{}
+	x.byID = map[string]*dockerclient.Image{}
+	for _, i := range images {
+		x.byID[i.Id] = i
+		for _, t := range i.RepoTags {
+			if ref, err := ParseReference(t); err == nil {
+				x.byTag[ref.Name()+":"+ref.Tag] = i
+			}
+		}
+		for _, d := range i.RepoDigests {
+			if ref, err := ParseReference(d); err == nil && ref.Digest != "" {
+				x.byDigest[ref.Name()+"@"+ref.Digest] = i
+			}
+		}
+	}
+	x.loaded = true
+	x.loadedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,123 @@
+package dockerutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func TestImageIndexListsOnce(t *testing.T) {
+	var listCalls int
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			listCalls++
+			return []*dockerclient.Image{
+				{
+					Id:          "a",
+					RepoTags:    []string{"redis:latest", "<none>:<none>"},
+					RepoDigests: []string{"redis@" + testDigest},
+				},
+				{Id: "b", RepoTags: []string{"example.com/app:1"}},
+			}, nil
+		},
+	}
+	x := NewImageIndex(client, 0)
+
+	for _, c := range []struct{ Name, ID string }{
+		{"redis", "a"},
+		{"docker.io/library/redis:latest", "a"},
+		{"redis@" + testDigest, "a"},
+		{"example.com/app:1", "b"},
+		{"example.com/app:2", ""},
+	} {
+		id, err := ImageID(client, c.Name, nil, WithImageIndex(x), WithPullPolicy(PullNever))
+		if c.ID == "" {
+			_, ok := err.(*ImageNotPresentError)
+			ensure.True(t, ok, c.Name)
+			continue
+		}
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, id, c.ID, c.Name)
+	}
+	ensure.DeepEqual(t, listCalls, 1)
+
+	i, err := x.Image("b")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, i.RepoTags, []string{"example.com/app:1"})
+}
+
+func TestImageIndexInvalidatedByPull(t *testing.T) {
+	var listCalls, pullCalls int
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			listCalls++
+			if pullCalls == 0 {
+				return nil, nil
+			}
+			return []*dockerclient.Image{{Id: "a", RepoTags: []string{"redis:latest"}}}, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			pullCalls++
+			return nil
+		},
+	}
+	x := NewImageIndex(client, 0)
+
+	id, err := ImageID(client, "redis", nil, WithImageIndex(x))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, id, "a")
+	ensure.DeepEqual(t, pullCalls, 1)
+	ensure.DeepEqual(t, listCalls, 2)
+}
+
+func TestImageIndexTTL(t *testing.T) {
+	var listCalls int
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			listCalls++
+			return nil, nil
+		},
+	}
+	x := NewImageIndex(client, time.Nanosecond)
+	ref, err := ParseReference("redis")
+	ensure.Nil(t, err)
+
+	_, err = x.ImageID(ref)
+	ensure.Nil(t, err)
+	time.Sleep(time.Millisecond)
+	_, err = x.ImageID(ref)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, listCalls, 2)
+}
+
+func TestImageIndexWatch(t *testing.T) {
+	var listCalls int
+	var callback dockerclient.Callback
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			listCalls++
+			return nil, nil
+		},
+		startMonitorEvents: func(cb dockerclient.Callback, ec chan error, args ...interface{}) {
+			callback = cb
+		},
+	}
+	x := NewImageIndex(client, 0)
+	x.Watch(nil)
+	ref, err := ParseReference("redis")
+	ensure.Nil(t, err)
+
+	_, err = x.ImageID(ref)
+	ensure.Nil(t, err)
+	callback(&dockerclient.Event{Status: "start"}, nil)
+	_, err = x.ImageID(ref)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, listCalls, 1)
+
+	callback(&dockerclient.Event{Status: "tag"}, nil)
+	_, err = x.ImageID(ref)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, listCalls, 2)
+}
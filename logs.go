@@ -0,0 +1,342 @@
+package dockerutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// Stream types in the header of each frame of a multiplexed log stream.
+const (
+	streamStdin  = 0
+	streamStdout = 1
+	streamStderr = 2
+)
+
+// DemuxLogs copies a multiplexed log stream, as returned for containers
+// without a TTY, splitting it into stdout and stderr. Each frame has an 8 byte
+// header holding the stream type and the big endian size of the payload.
+func DemuxLogs(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return stackerr.Wrap(err)
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case streamStdin, streamStdout:
+			w = stdout
+		case streamStderr:
+			w = stderr
+		default:
+			return stackerr.Newf("invalid stream type %d in log stream", header[0])
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return stackerr.Wrap(err)
+		}
+	}
+}
+
+// A LogStream identifies the stream a log line was written to.
+type LogStream int
+
+const (
+	// LogStdout is the standard output of the container.
+	LogStdout LogStream = iota + 1
+
+	// LogStderr is the standard error of the container.
+	LogStderr
+)
+
+func (s LogStream) String() string {
+	switch s {
+	case LogStdout:
+		return "stdout"
+	case LogStderr:
+		return "stderr"
+	}
+	return fmt.Sprintf("LogStream(%d)", int(s))
+}
+
+// A LogEntry is a line of container output.
+type LogEntry struct {
+	Stream LogStream
+
+	// Time is when the line was written. It is only set if the logs were
+	// requested with timestamps.
+	Time time.Time
+
+	// Line is the text of the line, without the line ending.
+	Line string
+}
+
+// ReadLogs decodes a log stream as returned by ContainerLogs, calling f for
+// every line. The stream is multiplexed unless the container has a TTY, in
+// which case all lines are on LogStdout. With timestamps the time prefixing
+// each line is parsed into the entry. An error returned by f stops reading and
+// is returned as is.
+func ReadLogs(r io.Reader, tty, timestamps bool, f func(LogEntry) error) error {
+	stdout := &logLineWriter{stream: LogStdout, timestamps: timestamps, f: f}
+	stderr := &logLineWriter{stream: LogStderr, timestamps: timestamps, f: f}
+
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, r)
+		err = stackerr.Wrap(err)
+	} else {
+		err = DemuxLogs(r, stdout, stderr)
+	}
+	if stdout.err != nil {
+		return stdout.err
+	}
+	if stderr.err != nil {
+		return stderr.err
+	}
+	if err != nil {
+		return err
+	}
+
+	// the output may end without a final line ending
+	if err := stdout.flush(); err != nil {
+		return err
+	}
+	return stderr.flush()
+}
+
+// logLineWriter splits the output of a stream into LogEntries.
+type logLineWriter struct {
+	stream     LogStream
+	timestamps bool
+	f          func(LogEntry) error
+	buf        bytes.Buffer
+	err        error
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buf.Next(i + 1))
+		if err := w.emit(strings.TrimRight(line, "\r\n")); err != nil {
+			w.err = err
+			return 0, err
+		}
+	}
+}
+
+func (w *logLineWriter) flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	line := w.buf.String()
+	w.buf.Reset()
+	return w.emit(line)
+}
+
+func (w *logLineWriter) emit(line string) error {
+	e := LogEntry{Stream: w.stream, Line: line}
+	if w.timestamps {
+		// the daemon separates the RFC 3339 timestamp with a space
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			if t, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+				e.Time = t
+				e.Line = line[i+1:]
+			}
+		} else if t, err := time.Parse(time.RFC3339Nano, line); err == nil {
+			e.Time = t
+			e.Line = ""
+		}
+	}
+	return w.f(e)
+}
+
+// FollowLogs follows the logs of the container, calling f for every line
+// written after since, until the log stream ends which happens when the
+// container stops. The daemon filters the logs by the second, so the earlier
+// lines within the second of since are skipped here. Clients other than a
+// *dockerclient.DockerClient can't ask the daemon to filter, in which case
+// all the earlier lines are skipped here. Lines without a valid timestamp
+// have a zero Time and are passed on along with the line before them. It
+// returns the time of the last line seen, which can be passed as since to
+// resume following without repeating lines, for example after the container
+// was restarted.
+func FollowLogs(
+	d dockerclient.Client,
+	id string,
+	since time.Time,
+	f func(LogEntry) error,
+) (time.Time, error) {
+	return followLogs(d, id, since, nil, f)
+}
+
+// followLogs is FollowLogs which also stops following when stop is closed.
+func followLogs(
+	d dockerclient.Client,
+	id string,
+	since time.Time,
+	stop <-chan struct{},
+	f func(LogEntry) error,
+) (time.Time, error) {
+	ci, err := d.InspectContainer(id)
+	if err != nil {
+		return since, stackerr.Wrap(err)
+	}
+	tty := ci.Config != nil && ci.Config.Tty
+
+	logs, err := followedLogs(d, id, since)
+	if err != nil {
+		return since, err
+	}
+	defer logs.Close()
+
+	// closing the stream ends the read below
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+			logs.Close()
+		case <-finished:
+		}
+	}()
+
+	// a line without a valid timestamp is kept along with the line before
+	// it, which is also where it is when the logs are read again
+	cursor := since
+	after := since.IsZero()
+	err = ReadLogs(logs, tty, true, func(e LogEntry) error {
+		if !e.Time.IsZero() {
+			after = e.Time.After(since)
+		}
+		if !after {
+			return nil
+		}
+		if e.Time.After(cursor) {
+			cursor = e.Time
+		}
+		return f(e)
+	})
+	return cursor, err
+}
+
+// followedLogs opens the followed log stream of the container. With a
+// *dockerclient.DockerClient the daemon skips the lines written before the
+// second of since, other clients return all the lines.
+func followedLogs(d dockerclient.Client, id string, since time.Time) (io.ReadCloser, error) {
+	dc, ok := d.(*dockerclient.DockerClient)
+	if !ok || since.IsZero() {
+		logs, err := d.ContainerLogs(id, &dockerclient.LogOptions{
+			Follow:     true,
+			Stdout:     true,
+			Stderr:     true,
+			Timestamps: true,
+		})
+		if err != nil {
+			return nil, stackerr.Wrap(err)
+		}
+		return logs, nil
+	}
+
+	query := url.Values{
+		"follow":     {"1"},
+		"stdout":     {"1"},
+		"stderr":     {"1"},
+		"timestamps": {"1"},
+		"since":      {strconv.FormatInt(since.Unix(), 10)},
+	}
+	res, err := apiRequest(dc, "GET", "/containers/"+id+"/logs", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// followRestartInterval is the delay before following a container again after
+// the log stream ended while it was still running or restarting.
+var followRestartInterval = time.Second
+
+// followRuns follows the logs of the container written after since across
+// restarts, until the container stops or stop is closed. It returns the state
+// the container stopped with, or nil if stop was closed first.
+func followRuns(
+	d dockerclient.Client,
+	id string,
+	since time.Time,
+	stop <-chan struct{},
+	f func(LogEntry) error,
+) (*dockerclient.State, error) {
+	for {
+		var err error
+		since, err = followLogs(d, id, since, stop, f)
+		select {
+		case <-stop:
+			return nil, nil
+		default:
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// the log stream also ends while the container is restarting
+		ci, err := d.InspectContainer(id)
+		if err != nil {
+			return nil, stackerr.Wrap(err)
+		}
+		if !ci.State.Running && !ci.State.Restarting {
+			return &ci.State, nil
+		}
+		select {
+		case <-time.After(followRestartInterval):
+		case <-stop:
+			return nil, nil
+		}
+	}
+}
+
+// TeeLogs copies the logs of the container to the writers for as long as it
+// runs, following it across restarts. Lines are written without timestamps.
+// It returns once the container has stopped. A LogfWriter turns a logger into
+// a writer.
+func TeeLogs(d dockerclient.Client, id string, stdout, stderr io.Writer) error {
+	_, err := followRuns(d, id, time.Time{}, nil, func(e LogEntry) error {
+		w := stdout
+		if e.Stream == LogStderr {
+			w = stderr
+		}
+		_, err := io.WriteString(w, e.Line+"\n")
+		return stackerr.Wrap(err)
+	})
+	return err
+}
+
+// LogfWriter returns a writer which calls logf for every line written to it,
+// with the given prefix. It works with log.Printf and testing.T.Logf. An
+// incomplete line is held back until its line ending is written.
+func LogfWriter(logf func(format string, args ...interface{}), prefix string) io.Writer {
+	return &logLineWriter{
+		f: func(e LogEntry) error {
+			logf("%s%s", prefix, e.Line)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,212 @@
+package dockerutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+// muxLogs returns a multiplexed log stream with a frame for each of the
+// alternating stream type and payload pairs.
+func muxLogs(frames ...interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(frames); i += 2 {
+		payload := frames[i+1].(string)
+		header := [8]byte{byte(frames[i].(int))}
+		binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+		buf.Write(header[:])
+		buf.WriteString(payload)
+	}
+	return buf.Bytes()
+}
+
+func TestDemuxLogs(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stream := muxLogs(streamStdout, "out 1\n", streamStderr, "err 1\n", streamStdout, "out 2\n")
+	ensure.Nil(t, DemuxLogs(bytes.NewReader(stream), &stdout, &stderr))
+	ensure.DeepEqual(t, stdout.String(), "out 1\nout 2\n")
+	ensure.DeepEqual(t, stderr.String(), "err 1\n")
+}
+
+func TestDemuxLogsTruncated(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stream := muxLogs(streamStdout, "out 1\n")
+	err := DemuxLogs(bytes.NewReader(stream[:10]), &stdout, &stderr)
+	ensure.Err(t, err, regexp.MustCompile("unexpected EOF"))
+}
+
+func TestDemuxLogsInvalidStream(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	err := DemuxLogs(bytes.NewReader(muxLogs(7, "x")), &stdout, &stderr)
+	ensure.Err(t, err, regexp.MustCompile("invalid stream type 7"))
+}
+
+func TestReadLogs(t *testing.T) {
+	stream := muxLogs(
+		streamStdout, "2015-06-01T10:00:00.000000001Z first\n2015-06-01T10:00:01Z sec",
+		streamStderr, "2015-06-01T10:00:02Z oops\r\n",
+		streamStdout, "ond\n2015-06-01T10:00:03Z last",
+	)
+	var entries []LogEntry
+	err := ReadLogs(bytes.NewReader(stream), false, true, func(e LogEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, entries, []LogEntry{
+		{Stream: LogStdout, Time: time.Date(2015, 6, 1, 10, 0, 0, 1, time.UTC), Line: "first"},
+		{Stream: LogStderr, Time: time.Date(2015, 6, 1, 10, 0, 2, 0, time.UTC), Line: "oops"},
+		{Stream: LogStdout, Time: time.Date(2015, 6, 1, 10, 0, 1, 0, time.UTC), Line: "second"},
+		{Stream: LogStdout, Time: time.Date(2015, 6, 1, 10, 0, 3, 0, time.UTC), Line: "last"},
+	})
+}
+
+func TestReadLogsTty(t *testing.T) {
+	var entries []LogEntry
+	err := ReadLogs(strings.NewReader("a\r\nb\n"), true, false, func(e LogEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, entries, []LogEntry{
+		{Stream: LogStdout, Line: "a"},
+		{Stream: LogStdout, Line: "b"},
+	})
+}
+
+func TestReadLogsStop(t *testing.T) {
+	givenErr := errors.New("stop")
+	var lines int
+	stream := muxLogs(streamStdout, "a\nb\n", streamStderr, "c\n")
+	err := ReadLogs(bytes.NewReader(stream), false, false, func(e LogEntry) error {
+		lines++
+		return givenErr
+	})
+	ensure.True(t, err == givenErr)
+	ensure.DeepEqual(t, lines, 1)
+}
+
+func TestFollowLogsSince(t *testing.T) {
+	stream := muxLogs(streamStdout, "2015-06-01T10:00:00Z old\n2015-06-01T10:00:01Z new\n")
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: id, Config: &dockerclient.ContainerConfig{}}, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			ensure.True(t, options.Follow)
+			ensure.True(t, options.Timestamps)
+			return ioutil.NopCloser(bytes.NewReader(stream)), nil
+		},
+	}
+
+	var lines []string
+	since := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+	cursor, err := FollowLogs(client, "x", since, func(e LogEntry) error {
+		lines = append(lines, e.Line)
+		return nil
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, lines, []string{"new"})
+	ensure.DeepEqual(t, cursor, time.Date(2015, 6, 1, 10, 0, 1, 0, time.UTC))
+}
+
+func TestFollowLogsWithoutTimestamp(t *testing.T) {
+	stream := muxLogs(streamStdout, "2015-06-01T10:00:00Z old\nstale\n2015-06-01T10:00:01Z new\nfresh\n")
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: id, Config: &dockerclient.ContainerConfig{}}, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			ensure.True(t, options.Follow)
+			ensure.True(t, options.Timestamps)
+			return ioutil.NopCloser(bytes.NewReader(stream)), nil
+		},
+	}
+
+	var lines []string
+	since := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+	cursor, err := FollowLogs(client, "x", since, func(e LogEntry) error {
+		lines = append(lines, e.Line)
+		return nil
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, lines, []string{"new", "fresh"})
+	ensure.DeepEqual(t, cursor, time.Date(2015, 6, 1, 10, 0, 1, 0, time.UTC))
+}
+
+func TestFollowedLogsSince(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ensure.DeepEqual(t, r.URL.Path, "/containers/x/logs")
+		ensure.DeepEqual(t, r.URL.Query().Get("since"), "1433152800")
+		ensure.DeepEqual(t, r.URL.Query().Get("follow"), "1")
+		ensure.DeepEqual(t, r.URL.Query().Get("timestamps"), "1")
+		io.WriteString(w, "logs")
+	}))
+	defer server.Close()
+
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+	logs, err := followedLogs(d, "x", time.Date(2015, 6, 1, 10, 0, 0, 500, time.UTC))
+	ensure.Nil(t, err)
+	defer logs.Close()
+	data, err := ioutil.ReadAll(logs)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(data), "logs")
+}
+
+func TestTeeLogsAcrossRestart(t *testing.T) {
+	defer func(d time.Duration) { followRestartInterval = d }(followRestartInterval)
+	followRestartInterval = 0
+
+	first := muxLogs(streamStdout, "2015-06-01T10:00:00Z a\n", streamStderr, "2015-06-01T10:00:01Z b\n")
+	second := append(first, muxLogs(streamStdout, "2015-06-01T10:00:02Z c\n")...)
+	streams := [][]byte{first, second}
+	states := []dockerclient.State{{Restarting: true}, {}}
+	var inspects int
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: id, Config: &dockerclient.ContainerConfig{}}
+			// every run of the logs is preceded by inspecting the container
+			// for its config and followed by inspecting its state
+			if inspects%2 == 1 {
+				ci.State = states[inspects/2]
+			}
+			inspects++
+			return ci, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			ensure.True(t, options.Follow)
+			ensure.True(t, options.Timestamps)
+			stream := streams[0]
+			streams = streams[1:]
+			return ioutil.NopCloser(bytes.NewReader(stream)), nil
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+	ensure.Nil(t, TeeLogs(client, "x", &stdout, &stderr))
+	ensure.DeepEqual(t, stdout.String(), "a\nc\n")
+	ensure.DeepEqual(t, stderr.String(), "b\n")
+}
+
+func TestLogfWriter(t *testing.T) {
+	var lines []string
+	w := LogfWriter(func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, "db: ")
+	io.WriteString(w, "one\ntw")
+	io.WriteString(w, "o\nthree")
+	ensure.DeepEqual(t, lines, []string{"db: one", "db: two"})
+}
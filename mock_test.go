@@ -0,0 +1,73 @@
+package dockerutil
+
+import (
+	"io"
+
+	"github.com/samalba/dockerclient"
+)
+
+// mockClient implements the methods of dockerclient.Client the tests need.
+// Calling any other method panics.
+type mockClient struct {
+	dockerclient.Client
+	listContainers     func(all, size bool, filters string) ([]dockerclient.Container, error)
+	inspectContainer   func(id string) (*dockerclient.ContainerInfo, error)
+	createContainer    func(config *dockerclient.ContainerConfig, name string) (string, error)
+	pullImage          func(name string, auth *dockerclient.AuthConfig) error
+	listImages         func() ([]*dockerclient.Image, error)
+	loadImage          func(reader io.Reader) error
+	removeImage        func(name string) ([]*dockerclient.ImageDelete, error)
+	removeContainer    func(id string, force, volumes bool) error
+	startContainer     func(id string, config *dockerclient.HostConfig) error
+	containerLogs      func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error)
+	killContainer      func(id, signal string) error
+	startMonitorEvents func(cb dockerclient.Callback, ec chan error, args ...interface{})
+}
+
+func (m *mockClient) ListContainers(all, size bool, filters string) ([]dockerclient.Container, error) {
+	return m.listContainers(all, size, filters)
+}
+
+func (m *mockClient) InspectContainer(id string) (*dockerclient.ContainerInfo, error) {
+	return m.inspectContainer(id)
+}
+
+func (m *mockClient) CreateContainer(config *dockerclient.ContainerConfig, name string) (string, error) {
+	return m.createContainer(config, name)
+}
+
+func (m *mockClient) PullImage(name string, auth *dockerclient.AuthConfig) error {
+	return m.pullImage(name, auth)
+}
+
+func (m *mockClient) ListImages() ([]*dockerclient.Image, error) {
+	return m.listImages()
+}
+
+func (m *mockClient) StartMonitorEvents(cb dockerclient.Callback, ec chan error, args ...interface{}) {
+	m.startMonitorEvents(cb, ec, args...)
+}
+
+func (m *mockClient) LoadImage(reader io.Reader) error {
+	return m.loadImage(reader)
+}
+
+func (m *mockClient) RemoveImage(name string) ([]*dockerclient.ImageDelete, error) {
+	return m.removeImage(name)
+}
+
+func (m *mockClient) RemoveContainer(id string, force, volumes bool) error {
+	return m.removeContainer(id, force, volumes)
+}
+
+func (m *mockClient) StartContainer(id string, config *dockerclient.HostConfig) error {
+	return m.startContainer(id, config)
+}
+
+func (m *mockClient) ContainerLogs(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+	return m.containerLogs(id, options)
+}
+
+func (m *mockClient) KillContainer(id, signal string) error {
+	return m.killContainer(id, signal)
+}
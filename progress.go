@@ -0,0 +1,256 @@
+package dockerutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// LayerProgress is the progress of a single layer of an image pull.
+type LayerProgress struct {
+	ID      string
+	Status  string
+	Current int64
+	Total   int64
+}
+
+// PullProgress is reported while an image is being pulled. Each report
+// carries either the layer which changed, or an image level status message
+// such as "Digest: sha256:...". Current and Total are summed over all layers
+// with a known size.
+type PullProgress struct {
+	Image   string
+	Layer   *LayerProgress
+	Status  string
+	Current int64
+	Total   int64
+	Layers  int
+	Done    int
+}
+
+// A ProgressFunc receives pull progress reports. It is called synchronously
+// from the goroutine performing the pull.
+type ProgressFunc func(PullProgress)
+
+// ProgressChan returns a ProgressFunc which sends the reports on the channel.
+// The pull blocks if the channel is not being drained.
+func ProgressChan(ch chan<- PullProgress) ProgressFunc {
+	return func(p PullProgress) {
+		ch <- p
+	}
+}
+
+// WithPullProgress reports the progress of any pull performed through the
+// given callback. Progress is only available when talking to the daemon
+// through a *dockerclient.DockerClient, other clients pull silently.
+func WithPullProgress(f ProgressFunc) PullOption {
+	return func(o *pullOptions) {
+		o.progress = f
+	}
+}
+
+// jsonMessage is a message in the JSON stream the daemon sends while pulling
+// or building.
+type jsonMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Stream         string `json:"stream"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// PullImageWithProgress pulls the image like dockerclient.Client.PullImage
+// but decodes the progress stream and reports it through the callback.
+func PullImageWithProgress(
+	d *dockerclient.DockerClient,
+	imageName string,
+	auth *dockerclient.AuthConfig,
+	progress ProgressFunc,
+) error {
+	header, err := registryAuthHeader(auth)
+	if err != nil {
+		return err
+	}
+
+	res, err := apiRequest(d, "POST", "/images/create", url.Values{"fromImage": {imageName}}, nil, header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return decodePullProgress(res.Body, imageName, progress)
+}
+
+// decodePullProgress reads the JSON progress stream until the end, reporting
+// progress along the way. An error message in the stream is returned.
+func decodePullProgress(r io.Reader, imageName string, progress ProgressFunc) error {
+	layers := map[string]*LayerProgress{}
+	dec := json.NewDecoder(r)
+	for {
+		var m jsonMessage
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return stackerr.Wrap(err)
+		}
+		if m.Error != "" {
+			return stackerr.Newf("pulling %q: %s", imageName, m.Error)
+		}
+		if progress == nil {
+			continue
+		}
+
+		p := PullProgress{Image: imageName}
+		if m.ID != "" && !strings.HasPrefix(m.Status, "Pulling from") {
+			l := layers[m.ID]
+			if l == nil {
+				l = &LayerProgress{ID: m.ID}
+				layers[m.ID] = l
+			}
+			l.Status = m.Status
+			if m.ProgressDetail.Total > 0 {
+				l.Current = m.ProgressDetail.Current
+				l.Total = m.ProgressDetail.Total
+			}
+			if layerDone(l.Status) && l.Total > 0 {
+				l.Current = l.Total
+			}
+			layer := *l
+			p.Layer = &layer
+		} else {
+			p.Status = m.Status
+		}
+
+		for _, l := range layers {
+			p.Current += l.Current
+			p.Total += l.Total
+			p.Layers++
+			if layerDone(l.Status) {
+				p.Done++
+			}
+		}
+		progress(p)
+	}
+}
+
+// layerDone reports if the layer status indicates the layer is available.
+func layerDone(status string) bool {
+	switch status {
+	case "Pull complete", "Already exists", "Download complete":
+		return true
+	}
+	return false
+}
+
+// TerminalProgress returns a ProgressFunc which renders the pull progress to
+// a terminal, with one continuously updated line per layer followed by a
+// total line.
+func TerminalProgress(w io.Writer) ProgressFunc {
+	var mu sync.Mutex
+	var order []string
+	layers := map[string]LayerProgress{}
+	lines := 0
+
+	return func(p PullProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if p.Layer != nil {
+			if _, ok := layers[p.Layer.ID]; !ok {
+				order = append(order, p.Layer.ID)
+			}
+			layers[p.Layer.ID] = *p.Layer
+		}
+
+		// move back up over what we rendered last time
+		if lines > 0 {
+			fmt.Fprintf(w, "\033[%dA", lines)
+		}
+		lines = 0
+		if p.Status != "" {
+			fmt.Fprintf(w, "\033[2K%s: %s\n", p.Image, p.Status)
+		}
+		for _, id := range order {
+			l := layers[id]
+			fmt.Fprintf(w, "\033[2K%s: %s %s\n", id, l.Status, formatProgress(l.Current, l.Total))
+			lines++
+		}
+		fmt.Fprintf(w, "\033[2K%s: %s\n", p.Image, formatTotals(p))
+		lines++
+	}
+}
+
+// LogProgress returns a ProgressFunc which writes plain log lines, suitable
+// for CI output. Layer status changes and image status messages are always
+// logged, byte level progress at most once per interval.
+func LogProgress(logf func(format string, args ...interface{}), interval time.Duration) ProgressFunc {
+	var mu sync.Mutex
+	statuses := map[string]string{}
+	var last time.Time
+
+	return func(p PullProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if p.Status != "" {
+			logf("%s: %s", p.Image, p.Status)
+			return
+		}
+
+		if p.Layer != nil && statuses[p.Layer.ID] != p.Layer.Status {
+			statuses[p.Layer.ID] = p.Layer.Status
+			if p.Layer.Status != "Downloading" && p.Layer.Status != "Extracting" {
+				logf("%s: %s %s", p.Image, p.Layer.ID, p.Layer.Status)
+			}
+		}
+
+		now := time.Now()
+		if now.Sub(last) < interval {
+			return
+		}
+		last = now
+		logf("%s: %s", p.Image, formatTotals(p))
+	}
+}
+
+func formatTotals(p PullProgress) string {
+	totals := fmt.Sprintf("%d/%d layers", p.Done, p.Layers)
+	if progress := formatProgress(p.Current, p.Total); progress != "" {
+		totals += " " + progress
+	}
+	return totals
+}
+
+func formatProgress(current, total int64) string {
+	if total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", formatBytes(current), formatBytes(total))
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1000 && i < len(units)-1 {
+		f /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", n, units[0])
+	}
+	return fmt.Sprintf("%.1f%s", f, units[i])
+}
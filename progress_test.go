@@ -0,0 +1,109 @@
+package dockerutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+const testPullStream = `{"status":"Pulling from library/redis","id":"latest"}
+{"status":"Already exists","id":"a"}
+{"status":"Pulling fs layer","id":"b"}
+{"status":"Downloading","progressDetail":{"current":50,"total":200},"id":"b"}
+{"status":"Downloading","progressDetail":{"current":200,"total":200},"id":"b"}
+{"status":"Pull complete","id":"b"}
+{"status":"Digest: sha256:abc"}
+`
+
+func TestDecodePullProgress(t *testing.T) {
+	var reports []PullProgress
+	err := decodePullProgress(strings.NewReader(testPullStream), "redis", func(p PullProgress) {
+		reports = append(reports, p)
+	})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(reports), 7)
+
+	ensure.DeepEqual(t, reports[0].Status, "Pulling from library/redis")
+	ensure.True(t, reports[0].Layer == nil)
+
+	ensure.DeepEqual(t, *reports[3].Layer, LayerProgress{
+		ID:      "b",
+		Status:  "Downloading",
+		Current: 50,
+		Total:   200,
+	})
+	ensure.DeepEqual(t, reports[3].Layers, 2)
+	ensure.DeepEqual(t, reports[3].Done, 1)
+
+	last := reports[6]
+	ensure.DeepEqual(t, last.Status, "Digest: sha256:abc")
+	ensure.DeepEqual(t, last.Current, int64(200))
+	ensure.DeepEqual(t, last.Total, int64(200))
+	ensure.DeepEqual(t, last.Done, 2)
+}
+
+func TestDecodePullProgressError(t *testing.T) {
+	stream := `{"status":"Pulling fs layer","id":"b"}
+{"error":"unauthorized: authentication required","errorDetail":{"message":"unauthorized"}}
+`
+	err := decodePullProgress(strings.NewReader(stream), "redis", nil)
+	ensure.Err(t, err, regexp.MustCompile("authentication required"))
+}
+
+func TestPullImageWithProgress(t *testing.T) {
+	auth := &dockerclient.AuthConfig{Username: "u", Password: "p"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ensure.DeepEqual(t, r.Method, "POST")
+		ensure.DeepEqual(t, r.URL.Path, "/images/create")
+		ensure.DeepEqual(t, r.URL.Query().Get("fromImage"), "redis:latest")
+
+		data, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		ensure.Nil(t, err)
+		var got dockerclient.AuthConfig
+		ensure.Nil(t, json.Unmarshal(data, &got))
+		ensure.DeepEqual(t, got, *auth)
+
+		fmt.Fprint(w, testPullStream)
+	}))
+	defer server.Close()
+
+	d, err := dockerclient.NewDockerClient(server.URL, nil)
+	ensure.Nil(t, err)
+
+	var reports int
+	err = PullImageWithProgress(d, "redis:latest", auth, func(PullProgress) { reports++ })
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, reports, 7)
+}
+
+func TestLogProgress(t *testing.T) {
+	var lines []string
+	logf := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	err := decodePullProgress(strings.NewReader(testPullStream), "redis", LogProgress(logf, time.Hour))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, lines, []string{
+		"redis: Pulling from library/redis",
+		"redis: a Already exists",
+		"redis: 1/1 layers",
+		"redis: b Pulling fs layer",
+		"redis: b Pull complete",
+		"redis: Digest: sha256:abc",
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	ensure.DeepEqual(t, formatBytes(999), "999B")
+	ensure.DeepEqual(t, formatBytes(1500), "1.5kB")
+	ensure.DeepEqual(t, formatBytes(120000000), "120.0MB")
+}
@@ -0,0 +1,183 @@
+package dockerutil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// A PullPolicy determines when images are pulled from the registry.
+type PullPolicy int
+
+const (
+	// PullIfNotPresent pulls an image only if it isn't available locally. It
+	// is the default.
+	PullIfNotPresent PullPolicy = iota
+
+	// PullAlways pulls an image every time, which refreshes moving tags such
+	// as "latest".
+	PullAlways
+
+	// PullNever never pulls an image. An ImageNotPresentError is returned if
+	// the image isn't available locally.
+	PullNever
+)
+
+func (p PullPolicy) String() string {
+	switch p {
+	case PullIfNotPresent:
+		return "IfNotPresent"
+	case PullAlways:
+		return "Always"
+	case PullNever:
+		return "Never"
+	}
+	return fmt.Sprintf("PullPolicy(%d)", int(p))
+}
+
+// ImageNotPresentError is returned when an image is not available locally and
+// the PullPolicy does not allow pulling it.
+type ImageNotPresentError struct {
+	Image string
+}
+
+func (e *ImageNotPresentError) Error() string {
+	return fmt.Sprintf("image %q is not present and the pull policy is Never", e.Image)
+}
+
+// PullOption configures how images are identified and pulled.
+type PullOption func(*pullOptions)
+
+type pullOptions struct {
+	policy    PullPolicy
+	progress  ProgressFunc
+	ctx       context.Context
+	index     *ImageIndex
+	bundleDir string
+	rewriter  *Rewriter
+	logf      func(format string, args ...interface{})
+
+	removeConflicting bool
+}
+
+func newPullOptions(options []PullOption) *pullOptions {
+	o := pullOptions{ctx: context.Background()}
+	for _, f := range options {
+		f(&o)
+	}
+	return &o
+}
+
+// WithPullPolicy specifies when images are pulled. The default is
+// PullIfNotPresent.
+func WithPullPolicy(p PullPolicy) PullOption {
+	return func(o *pullOptions) {
+		o.policy = p
+	}
+}
+
+// WithPullContext bounds how long the caller waits for a pull. Concurrent
+// pulls of the same image are shared between callers, so a canceled context
+// only stops this caller from waiting, the pull carries on for the others.
+func WithPullContext(ctx context.Context) PullOption {
+	return func(o *pullOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithLogf specifies a function which receives messages about things which
+// don't stop an image from being found, such as files in the bundle directory
+// which aren't bundles. Nothing is logged by default.
+func WithLogf(logf func(format string, args ...interface{})) PullOption {
+	return func(o *pullOptions) {
+		o.logf = logf
+	}
+}
+
+// pull pulls the image for the reference, as long as the policy allows it.
+// Concurrent pulls of the same reference with the same credentials are shared,
+// in which case only the progress callback of the first caller receives
+// reports.
+func (o *pullOptions) pull(d dockerclient.Client, ref *Reference, auth *dockerclient.AuthConfig) error {
+	if o.policy == PullNever {
+		return &ImageNotPresentError{Image: ref.Familiar()}
+	}
+	name := pullName(ref)
+	key := ref.Name() + ":" + ref.Tag
+	if ref.Digest != "" {
+		key = ref.Name() + "@" + ref.Digest
+	}
+	err := inFlightPulls.do(o.ctx, d, key, auth, func() error {
+		if dc, ok := d.(*dockerclient.DockerClient); ok && o.progress != nil {
+			return PullImageWithProgress(dc, name, auth, o.progress)
+		}
+		return stackerr.Wrap(d.PullImage(name, auth))
+	})
+	if o.index != nil {
+		o.index.Invalidate()
+	}
+	if err != nil {
+		return pullError(ref.Familiar(), err)
+	}
+	return nil
+}
+
+// imageID returns the ID of the local image for the reference, or an empty
+// string if there is none.
+func (o *pullOptions) imageID(d dockerclient.Client, ref *Reference) (string, error) {
+	if o.index != nil {
+		return o.index.ImageID(ref)
+	}
+	return imageIDFromList(d, ref)
+}
+
+// candidates returns the references to use for the image, in order of
+// preference, after applying the rewrite rules.
+func (o *pullOptions) candidates(ref *Reference) ([]*Reference, error) {
+	if o.rewriter == nil {
+		return []*Reference{ref}, nil
+	}
+	return o.rewriter.rewrite(ref)
+}
+
+// localImageID returns the first of the candidates which is available locally
+// along with its image ID, or nil if none of them are.
+func (o *pullOptions) localImageID(
+	d dockerclient.Client,
+	candidates []*Reference,
+) (*Reference, string, error) {
+	for _, c := range candidates {
+		id, err := o.imageID(d, c)
+		if err != nil {
+			return nil, "", err
+		}
+		if id != "" {
+			return c, id, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// pullAny pulls the candidates for the reference in order until one
+// succeeds, and returns it. The error of the last attempt is returned if all
+// of them fail.
+func (o *pullOptions) pullAny(
+	d dockerclient.Client,
+	ref *Reference,
+	candidates []*Reference,
+	auth *dockerclient.AuthConfig,
+) (*Reference, error) {
+	var err error
+	for _, c := range candidates {
+		if err = o.pull(d, c, auth); err == nil {
+			o.rewriter.logRewritten("pulled", ref, c)
+			return c, nil
+		}
+		if _, ok := err.(*ImageNotPresentError); ok {
+			return nil, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,149 @@
+package dockerutil
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/facebookgo/stackerr"
+)
+
+const (
+	// DefaultRegistry is the registry used for references which do not name
+	// one explicitly.
+	DefaultRegistry = "docker.io"
+
+	// DefaultTag is the tag used for references which have neither a tag nor
+	// a digest.
+	DefaultTag = "latest"
+
+	legacyDefaultRegistry = "index.docker.io"
+	officialRepoPrefix    = "library/"
+)
+
+var (
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp        = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// Reference is a parsed and normalized image reference. The zero value is not
+// a valid reference, use ParseReference to create one.
+type Reference struct {
+	// Registry is the registry host, possibly with a port. It is always set,
+	// references without one use DefaultRegistry.
+	Registry string
+
+	// Path is the repository path within the registry. Official images on the
+	// default registry include the "library/" prefix.
+	Path string
+
+	// Tag is the tag, if any. It defaults to DefaultTag if the reference has
+	// neither a tag nor a digest.
+	Tag string
+
+	// Digest is the content digest such as "sha256:...", if any.
+	Digest string
+}
+
+// ParseReference parses an image reference such as "redis",
+// "redis:latest", "docker.io/library/redis" or
+// "example.com:5000/team/app@sha256:...", and normalizes it according to the
+// rules the Docker daemon uses. All equivalent spellings of a reference
+// produce the same Reference.
+func ParseReference(s string) (*Reference, error) {
+	if s == "" {
+		return nil, stackerr.New("empty image reference")
+	}
+
+	var r Reference
+	remainder := s
+
+	if i := strings.Index(remainder, "@"); i != -1 {
+		r.Digest = remainder[i+1:]
+		remainder = remainder[:i]
+		if !digestRegexp.MatchString(r.Digest) {
+			return nil, stackerr.Newf("invalid digest in image reference %q", s)
+		}
+	}
+
+	// a tag follows the last colon, unless that colon is part of a registry
+	// host:port in which case a slash comes after it
+	if i := strings.LastIndex(remainder, ":"); i != -1 && !strings.Contains(remainder[i+1:], "/") {
+		r.Tag = remainder[i+1:]
+		remainder = remainder[:i]
+		if !tagRegexp.MatchString(r.Tag) {
+			return nil, stackerr.Newf("invalid tag in image reference %q", s)
+		}
+	}
+
+	if i := strings.Index(remainder, "/"); i != -1 && isRegistryHost(remainder[:i]) {
+		r.Registry = remainder[:i]
+		r.Path = remainder[i+1:]
+	} else {
+		r.Registry = DefaultRegistry
+		r.Path = remainder
+	}
+
+	if r.Registry == legacyDefaultRegistry {
+		r.Registry = DefaultRegistry
+	}
+	if r.Registry == DefaultRegistry && !strings.Contains(r.Path, "/") {
+		r.Path = officialRepoPrefix + r.Path
+	}
+
+	if r.Path == "" {
+		return nil, stackerr.Newf("missing repository in image reference %q", s)
+	}
+	for _, c := range strings.Split(r.Path, "/") {
+		if !pathComponentRegexp.MatchString(c) {
+			return nil, stackerr.Newf("invalid repository in image reference %q", s)
+		}
+	}
+
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = DefaultTag
+	}
+	return &r, nil
+}
+
+// isRegistryHost reports if the first component of a reference names a
+// registry rather than being part of the repository path.
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+// Name returns the fully qualified repository name, without the tag or digest.
+func (r *Reference) Name() string {
+	return r.Registry + "/" + r.Path
+}
+
+// FamiliarName returns the repository name in the short form the Docker
+// daemon uses in RepoTags, for example "redis" rather than
+// "docker.io/library/redis".
+func (r *Reference) FamiliarName() string {
+	if r.Registry != DefaultRegistry {
+		return r.Name()
+	}
+	return strings.TrimPrefix(r.Path, officialRepoPrefix)
+}
+
+// String returns the fully qualified reference.
+func (r *Reference) String() string {
+	return r.format(r.Name())
+}
+
+// Familiar returns the reference in the short form, for example
+// "redis:latest".
+func (r *Reference) Familiar() string {
+	return r.format(r.FamiliarName())
+}
+
+func (r *Reference) format(name string) string {
+	if r.Tag != "" {
+		name += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		name += "@" + r.Digest
+	}
+	return name
+}
@@ -0,0 +1,77 @@
+package dockerutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseReference(t *testing.T) {
+	cases := []struct {
+		Input    string
+		String   string
+		Familiar string
+	}{
+		{"redis", "docker.io/library/redis:latest", "redis:latest"},
+		{"redis:3", "docker.io/library/redis:3", "redis:3"},
+		{"library/redis", "docker.io/library/redis:latest", "redis:latest"},
+		{"docker.io/redis", "docker.io/library/redis:latest", "redis:latest"},
+		{"index.docker.io/library/redis", "docker.io/library/redis:latest", "redis:latest"},
+		{"team/app:v1", "docker.io/team/app:v1", "team/app:v1"},
+		{"localhost/app", "localhost/app:latest", "localhost/app:latest"},
+		{"localhost:5000/app", "localhost:5000/app:latest", "localhost:5000/app:latest"},
+		{"example.com:5000/a/b:c", "example.com:5000/a/b:c", "example.com:5000/a/b:c"},
+		{"app@" + testDigest, "docker.io/library/app@" + testDigest, "app@" + testDigest},
+		{"app:v1@" + testDigest, "docker.io/library/app:v1@" + testDigest, "app:v1@" + testDigest},
+	}
+	for _, c := range cases {
+		ref, err := ParseReference(c.Input)
+		ensure.Nil(t, err, c.Input)
+		ensure.DeepEqual(t, ref.String(), c.String, c.Input)
+		ensure.DeepEqual(t, ref.Familiar(), c.Familiar, c.Input)
+	}
+}
+
+func TestParseReferenceInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"Redis",
+		"redis:",
+		"redis:-bad",
+		"redis@sha256:short",
+		"example.com/",
+		"a//b",
+	}
+	for _, c := range cases {
+		_, err := ParseReference(c)
+		ensure.NotNil(t, err, c)
+	}
+}
+
+func TestReferenceMatchesTag(t *testing.T) {
+	ref, err := ParseReference("docker.io/library/redis")
+	ensure.Nil(t, err)
+	ensure.True(t, referenceMatchesTag(ref, "redis:latest"))
+	ensure.False(t, referenceMatchesTag(ref, "redis:3"))
+	ensure.False(t, referenceMatchesTag(ref, "other/redis:latest"))
+	ensure.False(t, referenceMatchesTag(ref, "<none>:<none>"))
+}
+
+func TestReferenceMatchesDigest(t *testing.T) {
+	ref, err := ParseReference("redis:3@" + testDigest)
+	ensure.Nil(t, err)
+	ensure.True(t, referenceMatchesDigest(ref, "redis@"+testDigest))
+	ensure.True(t, referenceMatchesDigest(ref, "docker.io/library/redis@"+testDigest))
+	ensure.False(t, referenceMatchesDigest(ref, "other@"+testDigest))
+	ensure.DeepEqual(t, pullName(ref), "redis@"+testDigest)
+
+	i := &dockerclient.Image{
+		RepoTags:    []string{"redis:3"},
+		RepoDigests: []string{"redis@sha256:" + strings.Repeat("f", 64)},
+	}
+	ensure.False(t, imageMatchesReference(i, ref))
+}
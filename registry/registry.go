@@ -0,0 +1,497 @@
+// Package registry provides a small client for the Docker Registry HTTP API
+// v2. It allows resolving tags to digests, checking if images exist and
+// listing tags, without pulling images through the Docker daemon.
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/facebookgo/dockerutil"
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// Media types of the manifests understood by the client.
+const (
+	MediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+	digestHeader      = "Docker-Content-Digest"
+)
+
+var acceptedManifestTypes = strings.Join([]string{
+	MediaTypeManifestList,
+	MediaTypeOCIIndex,
+	MediaTypeManifest,
+	MediaTypeOCIManifest,
+}, ", ")
+
+// ErrNotFound is returned when the repository, tag or manifest does not exist.
+var ErrNotFound = errors.New("registry: not found")
+
+// Platform identifies the platform of an image in a manifest list.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Descriptor describes content in the registry.
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Manifest is an image manifest or a manifest list. For a manifest list
+// Manifests holds the per platform manifests, for an image manifest Config
+// and Layers are set.
+type Manifest struct {
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"-"`
+	Config    *Descriptor  `json:"config,omitempty"`
+	Layers    []Descriptor `json:"layers,omitempty"`
+	Manifests []Descriptor `json:"manifests,omitempty"`
+}
+
+// IsList reports if the manifest is a multi-arch manifest list.
+func (m *Manifest) IsList() bool {
+	return m.MediaType == MediaTypeManifestList || m.MediaType == MediaTypeOCIIndex
+}
+
+// Client talks to registries using the HTTP API v2. The zero value is ready
+// to use.
+type Client struct {
+	// HTTPClient is used for all requests. It defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// InsecureRegistries lists registry hosts, including the port if any,
+	// which are spoken to over plain HTTP when HTTPS fails. Like the Docker
+	// daemon, registries on localhost are always treated this way.
+	InsecureRegistries []string
+
+	mu             sync.Mutex
+	authorizations map[string]string
+	plainHTTP      map[string]bool
+}
+
+// Digest returns the digest the registry serves for the image reference. For
+// multi-arch images this is the digest of the manifest list, which is also
+// what the Docker daemon records in RepoDigests.
+func (c *Client) Digest(imageName string, auth *dockerclient.AuthConfig) (string, error) {
+	ref, err := dockerutil.ParseReference(imageName)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := c.do("HEAD", ref, manifestPath(ref), auth)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if d := res.Header.Get(digestHeader); d != "" {
+		return d, nil
+	}
+
+	// some registries omit the digest header for HEAD requests
+	m, err := c.Manifest(imageName, auth)
+	if err != nil {
+		return "", err
+	}
+	return m.Digest, nil
+}
+
+// Exists reports if the image reference exists in the registry.
+func (c *Client) Exists(imageName string, auth *dockerclient.AuthConfig) (bool, error) {
+	_, err := c.Digest(imageName, auth)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Manifest fetches the manifest or manifest list for the image reference.
+func (c *Client) Manifest(imageName string, auth *dockerclient.AuthConfig) (*Manifest, error) {
+	ref, err := dockerutil.ParseReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do("GET", ref, manifestPath(ref), auth)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	if m.MediaType == "" {
+		m.MediaType = res.Header.Get("Content-Type")
+	}
+	m.Digest = res.Header.Get(digestHeader)
+	if m.Digest == "" {
+		sum := sha256.Sum256(body)
+		m.Digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return &m, nil
+}
+
+// PlatformDigest returns the digest of the image manifest for the given
+// platform. If the reference is not a manifest list its own digest is
+// returned.
+func (c *Client) PlatformDigest(
+	imageName string,
+	auth *dockerclient.AuthConfig,
+	platform Platform,
+) (string, error) {
+	m, err := c.Manifest(imageName, auth)
+	if err != nil {
+		return "", err
+	}
+	if !m.IsList() {
+		return m.Digest, nil
+	}
+
+	for _, d := range m.Manifests {
+		p := d.Platform
+		if p == nil || p.OS != platform.OS || p.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && p.Variant != platform.Variant {
+			continue
+		}
+		return d.Digest, nil
+	}
+	return "", stackerr.Newf(
+		"image %q has no manifest for %s/%s",
+		imageName,
+		platform.OS,
+		platform.Architecture,
+	)
+}
+
+// Tags lists the tags of the repository named by the image reference. Any tag
+// or digest in the reference is ignored.
+func (c *Client) Tags(imageName string, auth *dockerclient.AuthConfig) ([]string, error) {
+	ref, err := dockerutil.ParseReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	path := "/v2/" + ref.Path + "/tags/list"
+	for path != "" {
+		res, err := c.do("GET", ref, path, auth)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, stackerr.Wrap(err)
+		}
+		tags = append(tags, page.Tags...)
+		path = nextPage(res.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// nextPage extracts the path of the next page from a Link header such as
+// `</v2/foo/tags/list?last=b&n=2>; rel="next"`.
+func nextPage(link string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start == -1 || end < start {
+		return ""
+	}
+	return link[start+1 : end]
+}
+
+func manifestPath(ref *dockerutil.Reference) string {
+	tagOrDigest := ref.Tag
+	if ref.Digest != "" {
+		tagOrDigest = ref.Digest
+	}
+	return "/v2/" + ref.Path + "/manifests/" + tagOrDigest
+}
+
+func registryHost(ref *dockerutil.Reference) string {
+	if ref.Registry == dockerutil.DefaultRegistry {
+		return dockerHubRegistry
+	}
+	return ref.Registry
+}
+
+// insecure reports if the registry host may be spoken to over plain HTTP.
+func (c *Client) insecure(host string) bool {
+	for _, h := range c.InsecureRegistries {
+		if h == host {
+			return true
+		}
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c *Client) scheme(host string) string {
+	if c.usesPlainHTTP(host) {
+		return "http"
+	}
+	return "https"
+}
+
+func (c *Client) usesPlainHTTP(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.plainHTTP[host]
+}
+
+func (c *Client) setPlainHTTP(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.plainHTTP == nil {
+		c.plainHTTP = map[string]bool{}
+	}
+	c.plainHTTP[host] = true
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// do performs the request, authenticating and retrying once if the registry
+// asks for it. Only successful responses are returned.
+func (c *Client) do(
+	method string,
+	ref *dockerutil.Reference,
+	path string,
+	auth *dockerclient.AuthConfig,
+) (*http.Response, error) {
+	host := registryHost(ref)
+	u := c.scheme(host) + "://" + host + path
+	scope := "repository:" + ref.Path + ":pull"
+	key := authCacheKey(host, scope, auth)
+
+	res, err := c.request(method, u, c.cachedAuthorization(key))
+	if err != nil && !c.usesPlainHTTP(host) && c.insecure(host) {
+		c.setPlainHTTP(host)
+		u = "http://" + host + path
+		res, err = c.request(method, u, c.cachedAuthorization(key))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		res.Body.Close()
+
+		authorization, err := c.authorize(challenge, scope, auth)
+		if err != nil {
+			return nil, err
+		}
+		c.setAuthorization(key, authorization)
+
+		res, err = c.request(method, u, authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		return nil, stackerr.Newf("registry: %s %s: %s: %s", method, u, res.Status, body)
+	}
+	return res, nil
+}
+
+func (c *Client) request(method, u, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	req.Header.Set("Accept", acceptedManifestTypes)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	return res, nil
+}
+
+// authorize answers the WWW-Authenticate challenge and returns the value for
+// the Authorization header. Bearer challenges are answered by obtaining a
+// token from the realm, basic challenges with the credentials directly.
+func (c *Client) authorize(challenge, scope string, auth *dockerclient.AuthConfig) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	if strings.EqualFold(scheme, "basic") {
+		if auth == nil || auth.Username == "" {
+			return "", stackerr.New("registry: credentials required")
+		}
+		userPass := []byte(auth.Username + ":" + auth.Password)
+		return "Basic " + base64.StdEncoding.EncodeToString(userPass), nil
+	}
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return "", stackerr.Newf("registry: unsupported authentication challenge %q", challenge)
+	}
+
+	v := url.Values{}
+	if params["service"] != "" {
+		v.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+	v.Set("scope", scope)
+
+	req, err := http.NewRequest("GET", params["realm"]+"?"+v.Encode(), nil)
+	if err != nil {
+		return "", stackerr.Wrap(err)
+	}
+	if auth != nil && auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", stackerr.Wrap(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", stackerr.Newf("registry: token request failed: %s", res.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", stackerr.Wrap(err)
+	}
+	if body.Token != "" {
+		return "Bearer " + body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return "Bearer " + body.AccessToken, nil
+	}
+	return "", stackerr.New("registry: token response did not include a token")
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end == -1 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end:]
+			}
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return parts[0], params
+}
+
+// authCacheKey identifies an authorization by the credentials it was obtained
+// with, so different credentials for the same repository never share one.
+func authCacheKey(host, scope string, auth *dockerclient.AuthConfig) string {
+	var credentials [sha256.Size]byte
+	if auth != nil {
+		credentials = sha256.Sum256([]byte(auth.Username + "\x00" + auth.Password))
+	}
+	return fmt.Sprintf("%s|%s|%x", host, scope, credentials)
+}
+
+func (c *Client) cachedAuthorization(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authorizations[key]
+}
+
+func (c *Client) setAuthorization(key, authorization string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.authorizations == nil {
+		c.authorizations = map[string]string{}
+	}
+	c.authorizations[key] = authorization
+}
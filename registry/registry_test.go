@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	testDigest     = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testListDigest = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	testArmDigest  = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	testToken      = "secret-token"
+)
+
+// testRegistry is a minimal registry stand-in with token authentication.
+func testRegistry(t *testing.T) (*httptest.Server, *Client) {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ensure.DeepEqual(t, r.URL.Query().Get("service"), "test")
+		fmt.Fprintf(w, `{"token": %q}`, testToken)
+	})
+
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") == "Bearer "+testToken {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="%s/token",service="test",scope="repository:team/app:pull"`,
+			server.URL,
+		))
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	mux.HandleFunc("/v2/team/app/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/v2/team/app/manifests/") {
+		case "v1", testDigest:
+			w.Header().Set("Content-Type", MediaTypeManifest)
+			w.Header().Set(digestHeader, testDigest)
+			fmt.Fprintf(w, `{"mediaType": %q, "config": {"digest": "sha256:c"}}`, MediaTypeManifest)
+		case "multi":
+			w.Header().Set("Content-Type", MediaTypeManifestList)
+			w.Header().Set(digestHeader, testListDigest)
+			fmt.Fprintf(w, `{"mediaType": %q, "manifests": [
+				{"digest": %q, "platform": {"os": "linux", "architecture": "amd64"}},
+				{"digest": %q, "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}}
+			]}`, MediaTypeManifestList, testDigest, testArmDigest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	mux.HandleFunc("/v2/team/app/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/team/app/tags/list?last=b&n=2>; rel="next"`)
+			fmt.Fprint(w, `{"tags": ["a", "b"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"tags": ["c"]}`)
+	})
+
+	return server, &Client{HTTPClient: server.Client()}
+}
+
+func testAuth() *dockerclient.AuthConfig {
+	return &dockerclient.AuthConfig{Username: "user", Password: "pass"}
+}
+
+func testImage(server *httptest.Server, rest string) string {
+	return strings.TrimPrefix(server.URL, "https://") + "/team/app" + rest
+}
+
+func TestDigest(t *testing.T) {
+	server, client := testRegistry(t)
+	defer server.Close()
+	digest, err := client.Digest(testImage(server, ":v1"), testAuth())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, digest, testDigest)
+}
+
+func TestDigestWrongCredentials(t *testing.T) {
+	server, client := testRegistry(t)
+	defer server.Close()
+	_, err := client.Digest(testImage(server, ":v1"), &dockerclient.AuthConfig{Username: "user"})
+	ensure.Err(t, err, regexp.MustCompile("token request failed"))
+}
+
+func TestCachedAuthorizationPerCredentials(t *testing.T) {
+	server, client := testRegistry(t)
+	defer server.Close()
+	_, err := client.Digest(testImage(server, ":v1"), testAuth())
+	ensure.Nil(t, err)
+	wrong := &dockerclient.AuthConfig{Username: "user", Password: "wrong"}
+	_, err = client.Digest(testImage(server, ":v1"), wrong)
+	ensure.Err(t, err, regexp.MustCompile("token request failed"))
+}
+
+func TestDigestPlainHTTP(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ensure.DeepEqual(t, r.URL.Path, "/v2/team/app/manifests/v1")
+		w.Header().Set(digestHeader, testDigest)
+	}))
+	defer server.Close()
+
+	client := &Client{}
+	image := strings.TrimPrefix(server.URL, "http://") + "/team/app:v1"
+	for i := 0; i < 2; i++ {
+		digest, err := client.Digest(image, nil)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, digest, testDigest)
+	}
+	ensure.DeepEqual(t, requests, 2)
+}
+
+func TestInsecure(t *testing.T) {
+	client := &Client{InsecureRegistries: []string{"registry.internal:5000"}}
+	ensure.True(t, client.insecure("localhost:5000"))
+	ensure.True(t, client.insecure("127.0.0.1"))
+	ensure.True(t, client.insecure("[::1]:5000"))
+	ensure.True(t, client.insecure("registry.internal:5000"))
+	ensure.False(t, client.insecure("registry.internal"))
+	ensure.False(t, client.insecure(dockerHubRegistry))
+}
+
+func TestExists(t *testing.T) {
+	server, client := testRegistry(t)
+	defer server.Close()
+	exists, err := client.Exists(testImage(server, ":v1"), testAuth())
+	ensure.Nil(t, err)
+	ensure.True(t, exists)
+	exists, err = client.Exists(testImage(server, ":missing"), testAuth())
+	ensure.Nil(t, err)
+	ensure.False(t, exists)
+}
+
+func TestManifestByDigest(t *testing.T) {
+	server, client := testRegistry(t)
+	defer server.Close()
+	m, err := client.Manifest(testImage(server, "@"+testDigest), testAuth())
+	ensure.Nil(t, err)
+	ensure.False(t, m.IsList())
+	ensure.DeepEqual(t, m.Digest, testDigest)
+	ensure.DeepEqual(t, m.Config.Digest, "sha256:c")
+}
+
+func TestPlatformDigest(t *testing.T) {
+	server, client := testRegistry(t)
+	defer server.Close()
+	image := testImage(server, ":multi")
+
+	digest, err := client.Digest(image, testAuth())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, digest, testListDigest)
+
+	digest, err = client.PlatformDigest(image, testAuth(), Platform{OS: "linux", Architecture: "arm64"})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, digest, testArmDigest)
+
+	_, err = client.PlatformDigest(image, testAuth(), Platform{OS: "windows", Architecture: "amd64"})
+	ensure.Err(t, err, regexp.MustCompile("no manifest for windows/amd64"))
+
+	digest, err = client.PlatformDigest(testImage(server, ":v1"), testAuth(), Platform{OS: "linux"})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, digest, testDigest)
+}
+
+func TestTags(t *testing.T) {
+	server, client := testRegistry(t)
+	defer server.Close()
+	tags, err := client.Tags(testImage(server, ""), testAuth())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, tags, []string{"a", "b", "c"})
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://a/token",service="reg",scope="repository:x:pull,push"`)
+	ensure.DeepEqual(t, scheme, "Bearer")
+	ensure.DeepEqual(t, params, map[string]string{
+		"realm":   "https://a/token",
+		"service": "reg",
+		"scope":   "repository:x:pull,push",
+	})
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	ensure.DeepEqual(t, scheme, "Basic")
+	ensure.DeepEqual(t, params, map[string]string{"realm": "registry"})
+}
+
+func TestNextPage(t *testing.T) {
+	ensure.DeepEqual(t, nextPage(`</v2/x/tags/list?last=b>; rel="next"`), "/v2/x/tags/list?last=b")
+	ensure.DeepEqual(t, nextPage(""), "")
+}
@@ -0,0 +1,107 @@
+package dockerutil
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A RewriteRule maps image references to other names, for example to pull
+// from a mirror or to use an internal copy of an image. Rules match against
+// the fully qualified reference, such as "docker.io/library/redis:latest".
+type RewriteRule struct {
+	// Prefix matches references starting with it. It is replaced with each of
+	// the Replacements.
+	Prefix string
+
+	// Regexp matches references when Prefix is empty. The Replacements may
+	// refer to submatches as in regexp.Regexp.ReplaceAllString.
+	Regexp *regexp.Regexp
+
+	// Replacements are the names to try, in order. Include the matched prefix
+	// itself to fall back to the original registry, for example
+	// []string{"mirror.example.com/", "docker.io/"} for a Prefix "docker.io/".
+	Replacements []string
+}
+
+// A Rewriter rewrites image references using the first matching rule.
+type Rewriter struct {
+	Rules []RewriteRule
+
+	// Logf receives a message whenever a rewritten name is pulled or a
+	// container is created from it. Nothing is logged by default.
+	Logf func(format string, args ...interface{})
+}
+
+// Rewrite returns the names to use for the image, in order of preference. If
+// no rule matches the image itself is returned.
+func (r *Rewriter) Rewrite(imageName string) ([]string, error) {
+	ref, err := ParseReference(imageName)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := r.rewrite(ref)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.String()
+	}
+	return names, nil
+}
+
+func (r *Rewriter) rewrite(ref *Reference) ([]*Reference, error) {
+	full := ref.String()
+	for _, rule := range r.Rules {
+		var rewritten []string
+		switch {
+		case rule.Prefix != "":
+			if !strings.HasPrefix(full, rule.Prefix) {
+				continue
+			}
+			for _, replacement := range rule.Replacements {
+				rewritten = append(rewritten, replacement+full[len(rule.Prefix):])
+			}
+		case rule.Regexp != nil:
+			if !rule.Regexp.MatchString(full) {
+				continue
+			}
+			for _, replacement := range rule.Replacements {
+				rewritten = append(rewritten, rule.Regexp.ReplaceAllString(full, replacement))
+			}
+		default:
+			continue
+		}
+
+		candidates := make([]*Reference, 0, len(rewritten))
+		for _, name := range rewritten {
+			c, err := ParseReference(name)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, c)
+		}
+		if len(candidates) > 0 {
+			return candidates, nil
+		}
+	}
+	return []*Reference{ref}, nil
+}
+
+// logRewritten logs what was done with the rewritten name used for the
+// reference, unless the name is the same.
+func (r *Rewriter) logRewritten(action string, ref, used *Reference) {
+	if r == nil || r.Logf == nil || used.String() == ref.String() {
+		return
+	}
+	r.Logf("%s %s rewritten to %s", action, ref, used)
+}
+
+// WithRewriter makes ImageID and CreateWithPull rewrite image references
+// before looking for or pulling them. Pulls try the rewritten names in order
+// until one succeeds, and containers are created from the rewritten name.
+func WithRewriter(r *Rewriter) PullOption {
+	return func(o *pullOptions) {
+		o.rewriter = r
+	}
+}
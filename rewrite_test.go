@@ -0,0 +1,134 @@
+package dockerutil
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func testRewriter(logs *[]string) *Rewriter {
+	return &Rewriter{
+		Rules: []RewriteRule{
+			{
+				Regexp:       regexp.MustCompile(`^docker\.io/library/redis:(.*)$`),
+				Replacements: []string{"internal.example.com/redis:$1-patched"},
+			},
+			{
+				Prefix:       "docker.io/",
+				Replacements: []string{"mirror.example.com/", "docker.io/"},
+			},
+		},
+		Logf: func(format string, args ...interface{}) {
+			*logs = append(*logs, fmt.Sprintf(format, args...))
+		},
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	var logs []string
+	r := testRewriter(&logs)
+	cases := []struct {
+		Image string
+		Names []string
+	}{
+		{"redis:3", []string{"internal.example.com/redis:3-patched"}},
+		{"postgres", []string{"mirror.example.com/library/postgres:latest", "docker.io/library/postgres:latest"}},
+		{"example.com/app", []string{"example.com/app:latest"}},
+	}
+	for _, c := range cases {
+		names, err := r.Rewrite(c.Image)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, names, c.Names, c.Image)
+	}
+	ensure.DeepEqual(t, len(logs), 0)
+}
+
+func TestRewriteInvalid(t *testing.T) {
+	r := &Rewriter{
+		Rules: []RewriteRule{{Prefix: "docker.io/", Replacements: []string{"Bad/"}}},
+	}
+	_, err := r.Rewrite("redis")
+	ensure.NotNil(t, err)
+}
+
+func TestImageIDRewriteFallback(t *testing.T) {
+	var logs []string
+	var pulls []string
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			if len(pulls) < 2 {
+				return nil, nil
+			}
+			return []*dockerclient.Image{{Id: "a", RepoTags: []string{"postgres:latest"}}}, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			pulls = append(pulls, name)
+			if len(pulls) == 1 {
+				return errors.New("mirror down")
+			}
+			return nil
+		},
+	}
+	id, err := ImageID(client, "postgres", nil, WithRewriter(testRewriter(&logs)))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, id, "a")
+	ensure.DeepEqual(t, pulls, []string{"mirror.example.com/library/postgres:latest", "postgres:latest"})
+	ensure.DeepEqual(t, len(logs), 0)
+}
+
+func TestCreateWithPullRewritten(t *testing.T) {
+	var logs []string
+	given := &dockerclient.ContainerConfig{Image: "redis:3"}
+	var created []string
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			return []*dockerclient.Image{
+				{Id: "a", RepoTags: []string{"internal.example.com/redis:3-patched"}},
+			}, nil
+		},
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			created = append(created, config.Image)
+			return "c", nil
+		},
+	}
+	id, err := CreateWithPull(client, given, "x", nil, WithRewriter(testRewriter(&logs)))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, id, "c")
+	ensure.DeepEqual(t, created, []string{"internal.example.com/redis:3-patched"})
+	ensure.DeepEqual(t, given.Image, "redis:3")
+	ensure.DeepEqual(t, logs, []string{
+		"created container x from docker.io/library/redis:3 rewritten to internal.example.com/redis:3-patched",
+	})
+}
+
+func TestImageIDRewriteLogsPulls(t *testing.T) {
+	var logs []string
+	var pulled bool
+	client := &mockClient{
+		listImages: func() ([]*dockerclient.Image, error) {
+			if !pulled {
+				return nil, nil
+			}
+			return []*dockerclient.Image{
+				{Id: "a", RepoTags: []string{"mirror.example.com/library/postgres:latest"}},
+			}, nil
+		},
+		pullImage: func(name string, auth *dockerclient.AuthConfig) error {
+			pulled = true
+			return nil
+		},
+	}
+	r := testRewriter(&logs)
+	for i := 0; i < 2; i++ {
+		id, err := ImageID(client, "postgres", nil, WithRewriter(r))
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, id, "a")
+	}
+	ensure.DeepEqual(t, logs, []string{
+		"pulled docker.io/library/postgres:latest rewritten to mirror.example.com/library/postgres:latest",
+	})
+}
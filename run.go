@@ -0,0 +1,146 @@
+package dockerutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// ErrRunTimeout is returned by Run along with the partial result when the
+// container did not exit in time and was killed.
+var ErrRunTimeout = errors.New("dockerutil: run timed out")
+
+// runPollInterval is how often Run checks if the container exited after its
+// logs ended.
+var runPollInterval = 100 * time.Millisecond
+
+// RunOptions configures Run.
+type RunOptions struct {
+	// Name is the name of the container. The daemon picks one if it's empty.
+	Name string
+
+	// HostConfig is used to start the container.
+	HostConfig *dockerclient.HostConfig
+
+	// Auth is used to pull the image.
+	Auth *dockerclient.AuthConfig
+
+	// PullOptions are passed along to CreateWithPull.
+	PullOptions []PullOption
+
+	// Timeout is how long the container may run before it's killed. Zero
+	// means no limit.
+	Timeout time.Duration
+
+	// Remove removes the container once it exited, including when it timed
+	// out.
+	Remove bool
+}
+
+// RunResult is the outcome of Run.
+type RunResult struct {
+	// ID is the ID of the container.
+	ID string
+
+	// ExitCode is the exit code of the container. It is only meaningful if
+	// the container exited by itself.
+	ExitCode int
+
+	// Stdout and Stderr hold the output of the container. Containers with a
+	// TTY only have a Stdout.
+	Stdout []byte
+	Stderr []byte
+}
+
+// Run runs a container to completion, like "docker run" does. The container is
+// created using CreateWithPull, started, and waited for. The result holds its
+// exit code and output. A non zero exit code is not an error. If the Timeout
+// expires the container is killed, and the partial result is returned along
+// with ErrRunTimeout.
+func Run(d dockerclient.Client, c *dockerclient.ContainerConfig, o RunOptions) (*RunResult, error) {
+	id, err := CreateWithPull(d, c, o.Name, o.Auth, o.PullOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if o.Remove {
+		defer d.RemoveContainer(id, true, false)
+	}
+
+	if err := d.StartContainer(id, o.HostConfig); err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+
+	logs, err := d.ContainerLogs(id, &dockerclient.LogOptions{
+		Follow: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return nil, stackerr.Wrap(err)
+	}
+	defer logs.Close()
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		if c.Tty {
+			_, err := io.Copy(&stdout, logs)
+			done <- stackerr.Wrap(err)
+			return
+		}
+		done <- DemuxLogs(logs, &stdout, &stderr)
+	}()
+
+	var timeout <-chan time.Time
+	var deadline time.Time
+	if o.Timeout > 0 {
+		timer := time.NewTimer(o.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+		deadline = time.Now().Add(o.Timeout)
+	}
+
+	result := &RunResult{ID: id}
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	case <-timeout:
+		if err := d.KillContainer(id, "KILL"); err != nil {
+			return nil, stackerr.Wrap(err)
+		}
+		// the logs end along with the container, closing them makes sure
+		logs.Close()
+		<-done
+		result.Stdout = stdout.Bytes()
+		result.Stderr = stderr.Bytes()
+		return result, ErrRunTimeout
+	}
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+
+	// the logs may end slightly before the daemon marks the container as
+	// exited
+	for {
+		ci, err := d.InspectContainer(id)
+		if err != nil {
+			return nil, stackerr.Wrap(err)
+		}
+		if !ci.State.Running {
+			result.ExitCode = ci.State.ExitCode
+			return result, nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			if err := d.KillContainer(id, "KILL"); err != nil {
+				return nil, stackerr.Wrap(err)
+			}
+			return result, ErrRunTimeout
+		}
+		time.Sleep(runPollInterval)
+	}
+}
@@ -0,0 +1,134 @@
+package dockerutil
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+func TestRun(t *testing.T) {
+	var calls []string
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			calls = append(calls, "create "+name)
+			return "x", nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			calls = append(calls, "start "+id)
+			return nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			ensure.True(t, options.Follow)
+			ensure.True(t, options.Stdout)
+			ensure.True(t, options.Stderr)
+			logs := muxLogs(streamStdout, "migrated\n", streamStderr, "warning\n")
+			return ioutil.NopCloser(bytes.NewReader(logs)), nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			ci := &dockerclient.ContainerInfo{Id: id}
+			ci.State.ExitCode = 3
+			return ci, nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			calls = append(calls, "remove "+id)
+			return nil
+		},
+	}
+	c := &dockerclient.ContainerConfig{Image: "migrate"}
+	result, err := Run(client, c, RunOptions{Name: "migrate", Remove: true})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result, &RunResult{
+		ID:       "x",
+		ExitCode: 3,
+		Stdout:   []byte("migrated\n"),
+		Stderr:   []byte("warning\n"),
+	})
+	ensure.DeepEqual(t, calls, []string{"create migrate", "start x", "remove x"})
+}
+
+func TestRunTty(t *testing.T) {
+	var calls []string
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			calls = append(calls, "create "+name)
+			return "x", nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			calls = append(calls, "start "+id)
+			return nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader([]byte("raw output\r\n"))), nil
+		},
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: id}, nil
+		},
+	}
+	c := &dockerclient.ContainerConfig{Image: "gen", Tty: true}
+	result, err := Run(client, c, RunOptions{})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(result.Stdout), "raw output\r\n")
+	ensure.True(t, result.Stderr == nil)
+	ensure.DeepEqual(t, calls, []string{"create ", "start x"})
+}
+
+func TestRunTimeout(t *testing.T) {
+	var calls []string
+	pr, pw := io.Pipe()
+	go pw.Write(muxLogs(streamStdout, "partial\n"))
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			calls = append(calls, "create "+name)
+			return "x", nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			calls = append(calls, "start "+id)
+			return nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			return pr, nil
+		},
+		killContainer: func(id, signal string) error {
+			calls = append(calls, "kill "+id+" "+signal)
+			return nil
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			calls = append(calls, "remove "+id)
+			return nil
+		},
+	}
+	c := &dockerclient.ContainerConfig{Image: "slow"}
+	result, err := Run(client, c, RunOptions{
+		Timeout: 50 * time.Millisecond,
+		Remove:  true,
+	})
+	ensure.True(t, err == ErrRunTimeout)
+	ensure.DeepEqual(t, string(result.Stdout), "partial\n")
+	ensure.DeepEqual(t, calls, []string{"create ", "start x", "kill x KILL", "remove x"})
+}
+
+func TestRunStartError(t *testing.T) {
+	var calls []string
+	givenErr := dockerclient.Error{StatusCode: 500, Status: "500 Internal Server Error"}
+	client := &mockClient{
+		createContainer: func(config *dockerclient.ContainerConfig, name string) (string, error) {
+			calls = append(calls, "create "+name)
+			return "x", nil
+		},
+		startContainer: func(id string, config *dockerclient.HostConfig) error {
+			return givenErr
+		},
+		removeContainer: func(id string, force, volumes bool) error {
+			calls = append(calls, "remove "+id)
+			return nil
+		},
+	}
+	_, err := Run(client, &dockerclient.ContainerConfig{Image: "x"}, RunOptions{Remove: true})
+	ensure.NotNil(t, err)
+	ensure.DeepEqual(t, calls, []string{"create ", "remove x"})
+}
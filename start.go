@@ -6,34 +6,111 @@ import (
 )
 
 // CreateWithPull is the same as CreateContainer but will pull the image if it
-// isn't found and retry creating the container.
+// isn't found and retry creating the container. The image reference is
+// normalized before pulling, so a reference without a tag pulls "latest"
+// rather than every tag of the repository, and a reference with a digest
+// pulls by that digest. The options can change when and from where the image
+// is pulled. If the image is rewritten the container is created from the
+// rewritten name.
+//
+// Errors which callers may want to handle are returned as a
+// *NameConflictError, an *ImageNotFoundError or a *PullUnauthorizedError,
+// which can be checked using errors.Is with ErrNameConflict, ErrImageNotFound
+// and ErrPullUnauthorized respectively.
 func CreateWithPull(
 	d dockerclient.Client,
 	c *dockerclient.ContainerConfig,
 	name string,
 	ac *dockerclient.AuthConfig,
+	options ...PullOption,
 ) (string, error) {
 
-	id, err := d.CreateContainer(c, name)
+	ref, err := ParseReference(c.Image)
+	if err != nil {
+		return "", err
+	}
+	o := newPullOptions(options)
+	candidates, err := o.candidates(ref)
+	if err != nil {
+		return "", err
+	}
+
+	// refresh the image before creating the container, or when rewriting
+	// prefer the first rewritten image which is available locally
+	image := candidates[0]
+	if o.policy == PullAlways {
+		if image, err = o.pullAny(d, ref, candidates, ac); err != nil {
+			return "", err
+		}
+	} else if image != ref {
+		local, _, err := o.localImageID(d, candidates)
+		if err != nil {
+			return "", err
+		}
+		if local != nil {
+			image = local
+		}
+	}
+
+	id, err := o.createContainer(d, withImage(c, ref, image), name)
 	if err == nil {
+		o.rewriter.logRewritten("created container "+name+" from", ref, image)
 		return id, nil
 	}
 
-	// unknown error, bail
-	if err != dockerclient.ErrNotFound {
-		return "", stackerr.Wrap(err)
+	// unknown error, or the image was just pulled, bail
+	if err != dockerclient.ErrNotFound || o.policy == PullAlways {
+		return "", createError(c, err)
 	}
 
-	// need to pull the image
-	if err := d.PullImage(c.Image, ac); err != nil {
-		return "", stackerr.Wrap(err)
+	// load the image from a bundle if possible, otherwise pull it
+	bundledID, err := o.loadFromBundle(d, ref)
+	if err != nil {
+		return "", err
+	}
+	if bundledID != "" {
+		image = ref
+		// loaded images don't carry their digest, so refer to them by ID
+		if ref.Digest != "" {
+			withID := *c
+			withID.Image = bundledID
+			c = &withID
+		}
+	} else if image, err = o.pullAny(d, ref, candidates, ac); err != nil {
+		return "", err
 	}
 
-	// try again with the pulled image
-	id, err = d.CreateContainer(c, name)
+	// try again with the pulled or loaded image
+	id, err = o.createContainer(d, withImage(c, ref, image), name)
 	if err != nil {
-		return "", stackerr.Wrap(err)
+		return "", createError(c, err)
 	}
+	o.rewriter.logRewritten("created container "+name+" from", ref, image)
 
 	return id, nil
 }
+
+// createError returns the error to report for a failed create. The image is
+// missing if it was not found even though it was pulled or loaded.
+func createError(c *dockerclient.ContainerConfig, err error) error {
+	switch err.(type) {
+	case *NameConflictError:
+		return err
+	}
+	if err == dockerclient.ErrNotFound {
+		return &ImageNotFoundError{Image: c.Image, Err: err}
+	}
+	return stackerr.Wrap(err)
+}
+
+// withImage returns the config to create the container with. It is the given
+// one, unless the image was rewritten in which case it's a copy referring to
+// the rewritten image.
+func withImage(c *dockerclient.ContainerConfig, ref, image *Reference) *dockerclient.ContainerConfig {
+	if image == ref {
+		return c
+	}
+	rewritten := *c
+	rewritten.Image = pullName(image)
+	return &rewritten
+}
@@ -0,0 +1,105 @@
+package dockerutil
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/facebookgo/stackerr"
+	"github.com/samalba/dockerclient"
+)
+
+// waitForLogTail is the number of log lines kept for a WaitForLogError.
+const waitForLogTail = 20
+
+// errLogMatched stops reading the logs once WaitForLog saw enough matches.
+var errLogMatched = errors.New("dockerutil: log matched")
+
+// WaitForLogError is returned by WaitForLog when the container stopped or the
+// timeout expired before the expected log lines were written.
+type WaitForLogError struct {
+	Container string
+	Pattern   string
+	Wanted    int
+	Matches   int
+
+	// Exited is true if the container stopped, and false if the timeout
+	// expired.
+	Exited   bool
+	ExitCode int
+
+	// Tail holds the last lines of the logs.
+	Tail []string
+}
+
+func (e *WaitForLogError) Error() string {
+	var reason string
+	if e.Exited {
+		reason = fmt.Sprintf("container %q exited with code %d", e.Container, e.ExitCode)
+	} else {
+		reason = fmt.Sprintf("timed out waiting for container %q", e.Container)
+	}
+	msg := fmt.Sprintf("%s after %d of %d matches of %q", reason, e.Matches, e.Wanted, e.Pattern)
+	if len(e.Tail) > 0 {
+		msg += ", last log lines:\n" + strings.Join(e.Tail, "\n")
+	}
+	return msg
+}
+
+// WaitForLog follows the logs of the container until the regular expression
+// matched n lines, counting the lines written since the container started. It
+// is useful to wait for a service to be ready. A *WaitForLogError is returned
+// if the container stops or the timeout expires first. A zero timeout waits
+// for as long as the container runs.
+func WaitForLog(
+	d dockerclient.Client,
+	id string,
+	re *regexp.Regexp,
+	n int,
+	timeout time.Duration,
+) error {
+	if n < 1 {
+		n = 1
+	}
+	ci, err := d.InspectContainer(id)
+	if err != nil {
+		return stackerr.Wrap(err)
+	}
+
+	stop := make(chan struct{})
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { close(stop) })
+		defer timer.Stop()
+	}
+
+	// logs from earlier runs of the container don't count
+	werr := &WaitForLogError{Container: id, Pattern: re.String(), Wanted: n}
+	state, err := followRuns(d, id, ci.State.StartedAt, stop, func(e LogEntry) error {
+		werr.Tail = append(werr.Tail, e.Line)
+		if len(werr.Tail) > waitForLogTail {
+			werr.Tail = werr.Tail[1:]
+		}
+		if re.MatchString(e.Line) {
+			werr.Matches++
+			if werr.Matches >= n {
+				return errLogMatched
+			}
+		}
+		return nil
+	})
+	if werr.Matches >= n {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the timeout expired if the container didn't stop
+	if state != nil {
+		werr.Exited = true
+		werr.ExitCode = state.ExitCode
+	}
+	return werr
+}
@@ -0,0 +1,129 @@
+package dockerutil
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/samalba/dockerclient"
+)
+
+var testReadyLogs = muxLogs(
+	streamStdout, "2015-06-01T10:00:00Z ready to accept connections\n",
+	streamStderr, "2015-06-01T10:00:01Z restarting\n",
+	streamStdout, "2015-06-01T10:00:02Z ready to accept connections\n",
+)
+
+func TestWaitForLog(t *testing.T) {
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: id}, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(testReadyLogs)), nil
+		},
+	}
+	re := regexp.MustCompile("ready to accept")
+	ensure.Nil(t, WaitForLog(client, "db", re, 2, time.Minute))
+}
+
+func TestWaitForLogSkipsEarlierRuns(t *testing.T) {
+	state := dockerclient.State{
+		StartedAt: time.Date(2015, 6, 1, 10, 0, 1, 0, time.UTC),
+		ExitCode:  1,
+	}
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: id, State: state}, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(testReadyLogs)), nil
+		},
+	}
+	re := regexp.MustCompile("ready to accept")
+	err := WaitForLog(client, "db", re, 2, time.Minute)
+	werr, ok := err.(*WaitForLogError)
+	ensure.True(t, ok, err)
+	ensure.DeepEqual(t, werr.Matches, 1)
+}
+
+func TestWaitForLogExited(t *testing.T) {
+	state := dockerclient.State{ExitCode: 3}
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: id, State: state}, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(testReadyLogs)), nil
+		},
+	}
+	re := regexp.MustCompile("ready to accept")
+	err := WaitForLog(client, "db", re, 3, time.Minute)
+	ensure.DeepEqual(t, err, &WaitForLogError{
+		Container: "db",
+		Pattern:   "ready to accept",
+		Wanted:    3,
+		Matches:   2,
+		Exited:    true,
+		ExitCode:  3,
+		Tail: []string{
+			"ready to accept connections",
+			"restarting",
+			"ready to accept connections",
+		},
+	})
+	ensure.Err(t, err, regexp.MustCompile(`container "db" exited with code 3 after 2 of 3 matches`))
+}
+
+func TestWaitForLogTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	go pw.Write(testReadyLogs)
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			return &dockerclient.ContainerInfo{Id: id, State: dockerclient.State{Running: true}}, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			return pr, nil
+		},
+	}
+	re := regexp.MustCompile("ready to accept")
+	err := WaitForLog(client, "db", re, 3, 50*time.Millisecond)
+	werr, ok := err.(*WaitForLogError)
+	ensure.True(t, ok, err)
+	ensure.False(t, werr.Exited)
+	ensure.DeepEqual(t, werr.Matches, 2)
+	ensure.Err(t, err, regexp.MustCompile(`timed out waiting for container "db"`))
+}
+
+func TestWaitForLogAcrossRestart(t *testing.T) {
+	defer func(d time.Duration) { followRestartInterval = d }(followRestartInterval)
+	followRestartInterval = 0
+
+	// the line without a timestamp must not reset the cursor, otherwise the
+	// first line is counted again once the logs are read after the restart
+	first := muxLogs(streamStdout, "2015-06-01T10:00:00Z ready\ngarbled\n")
+	streams := [][]byte{first, append(first, muxLogs(streamStdout, "2015-06-01T10:00:02Z ready\n")...)}
+	states := []dockerclient.State{{Running: true}, {}, {Restarting: true}, {}, {ExitCode: 1}}
+	var inspects int
+	client := &mockClient{
+		inspectContainer: func(id string) (*dockerclient.ContainerInfo, error) {
+			inspects++
+			return &dockerclient.ContainerInfo{Id: id, State: states[inspects-1]}, nil
+		},
+		containerLogs: func(id string, options *dockerclient.LogOptions) (io.ReadCloser, error) {
+			stream := streams[0]
+			streams = streams[1:]
+			return ioutil.NopCloser(bytes.NewReader(stream)), nil
+		},
+	}
+	err := WaitForLog(client, "db", regexp.MustCompile("ready"), 3, time.Minute)
+	werr, ok := err.(*WaitForLogError)
+	ensure.True(t, ok, err)
+	ensure.True(t, werr.Exited)
+	ensure.DeepEqual(t, werr.Matches, 2)
+	ensure.DeepEqual(t, werr.Tail, []string{"ready", "garbled", "ready"})
+}